@@ -27,10 +27,12 @@ func main() {
 
 	log := setupLogger(cfg.Env)
 
-	application := app.NewApp(log, cfg.Grpc.Port, cfg.Db, cfg.Cache, cfg.Queue)
+	application := app.NewApp(log, cfg.Grpc.Port, cfg.Db, cfg.Cache, cfg.Queue, cfg.Sweeper, cfg.Holdout, cfg.Stats)
 
 	go application.GrpcServer.MustRun()
 	go application.KafkaConsumer.MustRun(context.Background())
+	go application.Sweeper.MustRun(context.Background())
+	go application.Stats.MustRun(context.Background())
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -42,6 +44,10 @@ func main() {
 	application.GrpcServer.Stop()
 
 	log.Info("gracefully stopped grpc server")
+
+	application.Sweeper.Stop()
+
+	application.Stats.Stop()
 }
 
 // setupLogger - Настраивает логгер slog, в зависимости от окружения
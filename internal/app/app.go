@@ -4,7 +4,10 @@ import (
 	"log/slog"
 	grpcapp "main/internal/app/grpc"
 	"main/internal/app/kafka"
+	"main/internal/app/stats"
+	"main/internal/app/sweeper"
 	"main/internal/config"
+	"main/internal/domain/bucketing"
 	kafkahandler "main/internal/kafka"
 	"main/internal/repository/postgres"
 	"main/internal/repository/redis"
@@ -16,10 +19,12 @@ import (
 type App struct {
 	GrpcServer    *grpcapp.App
 	KafkaConsumer *kafka.App
+	Sweeper       *sweeper.App
+	Stats         *stats.App
 }
 
 // NewApp - Конструктор App
-func NewApp(log *slog.Logger, grpcPort int, dbConfig config.DbConfig, cacheConfig config.CacheConfig, queueConfig config.QueueConfig) *App {
+func NewApp(log *slog.Logger, grpcPort int, dbConfig config.DbConfig, cacheConfig config.CacheConfig, queueConfig config.QueueConfig, sweeperConfig config.SweeperConfig, holdoutConfig config.HoldoutConfig, statsConfig config.StatsConfig) *App {
 
 	shards := make([]string, 0)
 
@@ -27,7 +32,13 @@ func NewApp(log *slog.Logger, grpcPort int, dbConfig config.DbConfig, cacheConfi
 		shards = append(shards, cfg.DSN)
 	}
 
-	repository, err := postgres.NewSegmentationStorage(dbConfig.NumShards, shards, log)
+	if holdoutConfig.Percentage < 0 || holdoutConfig.Percentage > 100 {
+		panic("holdout percentage must be between 0 and 100")
+	}
+
+	holdout := bucketing.NewHoldout(holdoutConfig.Percentage, holdoutConfig.Salt)
+
+	repository, err := postgres.NewSegmentationStorage(dbConfig.NumShards, shards, holdout, log)
 
 	if err != nil {
 		panic("failed to connect to database: " + err.Error())
@@ -49,8 +60,14 @@ func NewApp(log *slog.Logger, grpcPort int, dbConfig config.DbConfig, cacheConfi
 
 	kafkaApp := kafka.New(log, messageHandler, queueConfig.Brokers, queueConfig.Topics, queueConfig.Group)
 
+	sweeperApp := sweeper.New(log, segService, sweeperConfig.Interval)
+
+	statsApp := stats.New(log, segService, statsConfig.Interval)
+
 	return &App{
 		GrpcServer:    grpcApp,
 		KafkaConsumer: kafkaApp,
+		Sweeper:       sweeperApp,
+		Stats:         statsApp,
 	}
 }
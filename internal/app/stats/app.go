@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StatsSnapshotter - интерфейс сервиса, который умеет записывать снимок числа участников сегментов
+type StatsSnapshotter interface {
+	SnapshotSegmentStats() error
+}
+
+/*
+	App - фоновая задача, которая периодически записывает снимок числа участников всех сегментов.
+
+Снимок хранится по дням, поэтому за день остается последний из них
+*/
+type App struct {
+	log         *slog.Logger
+	snapshotter StatsSnapshotter
+	interval    time.Duration
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// New - конструктор App
+func New(log *slog.Logger, snapshotter StatsSnapshotter, interval time.Duration) *App {
+	return &App{
+		log:         log,
+		snapshotter: snapshotter,
+		interval:    interval,
+		stop:        make(chan struct{}),
+	}
+}
+
+// MustRun - Запуск периодических снимков. Первый снимок делается сразу. При некорректном интервале паникует
+func (a *App) MustRun(ctx context.Context) {
+	const op = "statsapp.Run"
+	log := a.log.With(slog.String("op", op))
+
+	if a.interval <= 0 {
+		panic("stats interval must be positive")
+	}
+
+	log.Info("starting segment stats snapshots", slog.Duration("interval", a.interval))
+
+	a.snapshot()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.snapshot()
+		}
+	}
+}
+
+// snapshot - один снимок числа участников сегментов
+func (a *App) snapshot() {
+	if err := a.snapshotter.SnapshotSegmentStats(); err != nil {
+		a.log.Error("failed to snapshot segment stats", slog.Any("error", err))
+		return
+	}
+
+	a.log.Info("segment stats snapshot taken")
+}
+
+// Stop - остановка снимков
+func (a *App) Stop() error {
+	const op = "statsapp.Stop"
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping segment stats snapshots")
+
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	return nil
+}
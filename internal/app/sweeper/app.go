@@ -0,0 +1,85 @@
+package sweeper
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ExpiredSegmentsRemover - интерфейс сервиса, который умеет удалять истекшие сегменты
+type ExpiredSegmentsRemover interface {
+	DeleteExpiredSegments() ([]string, error)
+}
+
+// App - фоновая задача, которая периодически удаляет сегменты с истекшим сроком действия
+type App struct {
+	log      *slog.Logger
+	remover  ExpiredSegmentsRemover
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New - конструктор App
+func New(log *slog.Logger, remover ExpiredSegmentsRemover, interval time.Duration) *App {
+	return &App{
+		log:      log,
+		remover:  remover,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// MustRun - Запуск периодического удаления истекших сегментов. При некорректном интервале паникует
+func (a *App) MustRun(ctx context.Context) {
+	const op = "sweeperapp.Run"
+	log := a.log.With(slog.String("op", op))
+
+	if a.interval <= 0 {
+		panic("sweeper interval must be positive")
+	}
+
+	log.Info("starting expired segments sweeper", slog.Duration("interval", a.interval))
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.sweep()
+		}
+	}
+}
+
+// sweep - один проход удаления истекших сегментов
+func (a *App) sweep() {
+	deleted, err := a.remover.DeleteExpiredSegments()
+	if err != nil {
+		a.log.Error("failed to delete expired segments", slog.Any("error", err))
+		return
+	}
+
+	if len(deleted) > 0 {
+		a.log.Info("expired segments deleted", slog.Any("ids", deleted))
+	}
+}
+
+// Stop - остановка sweeper-а
+func (a *App) Stop() error {
+	const op = "sweeperapp.Stop"
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping expired segments sweeper")
+
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+
+	return nil
+}
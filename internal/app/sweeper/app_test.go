@@ -0,0 +1,95 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRemover - удаляет по одному заранее заданному сегменту за вызов и считает вызовы
+type fakeRemover struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (r *fakeRemover) DeleteExpiredSegments() ([]string, error) {
+	r.calls.Add(1)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return []string{"EXPIRED"}, nil
+}
+
+func newTestApp(remover ExpiredSegmentsRemover, interval time.Duration) *App {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), remover, interval)
+}
+
+func TestSweeperRunsEveryInterval(t *testing.T) {
+	remover := &fakeRemover{}
+	app := newTestApp(remover, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		app.MustRun(context.Background())
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return remover.calls.Load() >= 3 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, app.Stop())
+	require.NoError(t, app.Stop(), "repeated stop must not panic")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop")
+	}
+}
+
+func TestSweeperKeepsRunningAfterError(t *testing.T) {
+	remover := &fakeRemover{err: errors.New("shard unavailable")}
+	app := newTestApp(remover, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.MustRun(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return remover.calls.Load() >= 2 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop on context cancel")
+	}
+}
+
+func TestSweeperDoesNotRunBeforeInterval(t *testing.T) {
+	remover := &fakeRemover{}
+	app := newTestApp(remover, time.Hour)
+
+	go app.MustRun(context.Background())
+	t.Cleanup(func() { _ = app.Stop() })
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, remover.calls.Load())
+}
+
+func TestSweeperPanicsOnInvalidInterval(t *testing.T) {
+	app := newTestApp(&fakeRemover{}, 0)
+
+	assert.Panics(t, func() { app.MustRun(context.Background()) })
+}
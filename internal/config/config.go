@@ -10,11 +10,29 @@ import (
 
 // Config - структура конфигов приложения
 type Config struct {
-	Env   string      `yaml:"env" env-default:"local"`
-	Grpc  GrpcConfig  `yaml:"grpc"`
-	Db    DbConfig    `yaml:"db"`
-	Cache CacheConfig `yaml:"cache"`
-	Queue QueueConfig `yaml:"queue"`
+	Env     string        `yaml:"env" env-default:"local"`
+	Grpc    GrpcConfig    `yaml:"grpc"`
+	Db      DbConfig      `yaml:"db"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Queue   QueueConfig   `yaml:"queue"`
+	Sweeper SweeperConfig `yaml:"sweeper"`
+	Stats   StatsConfig   `yaml:"stats"`
+	Holdout HoldoutConfig `yaml:"holdout"`
+}
+
+// HoldoutConfig - глобальная контрольная группа. Смена соли или уменьшение процента меняет ее состав
+type HoldoutConfig struct {
+	Percentage float64 `yaml:"percentage" env-default:"0"`
+	Salt       string  `yaml:"salt" env-default:"global_holdout"`
+}
+
+type SweeperConfig struct {
+	Interval time.Duration `yaml:"interval" env-default:"1m"`
+}
+
+// StatsConfig - периодичность снимков числа участников сегментов
+type StatsConfig struct {
+	Interval time.Duration `yaml:"interval" env-default:"24h"`
 }
 
 type QueueConfig struct {
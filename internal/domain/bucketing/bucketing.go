@@ -0,0 +1,110 @@
+/*
+Package bucketing - детерминированное разбиение пользователей на бакеты для распространения сегментов.
+
+Пользователь попадает в бакет по хэшу (segment_id, salt, user_id), поэтому для одного и того же сегмента
+выборка воспроизводима: 10% - это всегда одни и те же пользователи, а повторное распространение идемпотентно
+*/
+package bucketing
+
+import (
+	"crypto/md5" // #nosec G501 -- md5 используется для равномерного разбиения, а не для защиты данных
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// BucketsNum - число бакетов. Один бакет - 0.01% пользователей
+const BucketsNum = 10000
+
+// Bucket - номер бакета пользователя userId для сегмента segmentId с солью salt, от 0 до BucketsNum-1
+func Bucket(segmentId, salt string, userId int) int {
+	sum := md5.Sum([]byte(segmentId + ":" + salt + ":" + strconv.Itoa(userId))) // #nosec G401
+	return int(binary.BigEndian.Uint32(sum[:4]) % BucketsNum)
+}
+
+// holdoutKey - ключ, по которому пользователи делятся на бакеты глобальной контрольной группы
+const holdoutKey = "global_holdout"
+
+/*
+	Holdout - глобальная контрольная группа: пользователи, которые не попадают ни в один сегмент при распространении.
+
+Группа определяется долей бакетов и солью, поэтому состав воспроизводим и не зависит от сегментов
+*/
+type Holdout struct {
+	Buckets int
+	Salt    string
+}
+
+// NewHoldout - контрольная группа на percentage процентов пользователей
+func NewHoldout(percentage float64, salt string) Holdout {
+	return Holdout{Buckets: PercentageToBuckets(percentage), Salt: salt}
+}
+
+// Percentage - доля контрольной группы в процентах
+func (h Holdout) Percentage() float64 {
+	return float64(h.Buckets) * 100 / BucketsNum
+}
+
+// Contains - проверка, что пользователь userId в контрольной группе
+func (h Holdout) Contains(userId int) bool {
+	return h.Buckets > 0 && Bucket(holdoutKey, h.Salt, userId) < h.Buckets
+}
+
+/*
+	SQL - условие postgres, что пользователь userIdExpr в контрольной группе. Совпадает с Contains.
+
+Соль и ключ передаются параметрами, они дописываются к args
+*/
+func (h Holdout) SQL(userIdExpr string, args []any) (string, []any) {
+	if h.Buckets <= 0 {
+		return "FALSE", args
+	}
+
+	args = append(args, holdoutKey, h.Salt)
+	bucket := SQL(fmt.Sprintf("$%d::text", len(args)-1), fmt.Sprintf("$%d::text", len(args)), userIdExpr)
+
+	return fmt.Sprintf("(%s < %d)", bucket, h.Buckets), args
+}
+
+// PercentageToBuckets - число бакетов, соответствующее проценту пользователей
+func PercentageToBuckets(percentage float64) int {
+	return int(percentage*BucketsNum/100 + 0.5)
+}
+
+/*
+	Variant - номер варианта эксперимента с весами weights для пользователя userId.
+
+Вариант выбирается по отдельному хэшу, а не по бакету распространения, поэтому при увеличении процента
+уже попавшие в сегмент пользователи остаются в своих вариантах. Та же формула используется в триггере
+assign_users_segments_variant
+*/
+func Variant(segmentId, salt string, userId int, weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	point := Bucket(segmentId+":variant", salt, userId) * total / BucketsNum
+
+	upper := 0
+	for i, w := range weights {
+		upper += w
+		if point < upper {
+			return i
+		}
+	}
+
+	return len(weights) - 1
+}
+
+/*
+	SQL - выражение postgres, вычисляющее тот же бакет, что и Bucket.
+
+Аргументы - SQL-выражения для id сегмента, соли и id пользователя
+*/
+func SQL(segmentIdExpr, saltExpr, userIdExpr string) string {
+	return fmt.Sprintf(
+		"(('x' || substr(md5(%s || ':' || %s || ':' || %s::text), 1, 8))::bit(32)::bigint %% %d)",
+		segmentIdExpr, saltExpr, userIdExpr, BucketsNum,
+	)
+}
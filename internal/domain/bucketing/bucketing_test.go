@@ -0,0 +1,114 @@
+package bucketing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Значения посчитаны независимо: int(md5("key:salt:uid")[:4] как big-endian) % 10000.
+// Если они поменяются, все пользователи перераспределятся по бакетам
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		key    string
+		salt   string
+		userId int
+		want   int
+	}{
+		{"NEW_UI", "", 1, 8065},
+		{"NEW_UI", "", 2, 526},
+		{"NEW_UI", "s1", 1, 7820},
+		{"checkout", "abc", 42, 4374},
+		{"checkout", "abc", 1000000, 2324},
+		{"global_holdout", "global_holdout", 7, 5819},
+		{"EXP:variant", "salt", 5, 1899},
+		{"EXP:variant", "salt", 6, 9263},
+		{"EXP:variant", "salt", 7, 8286},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Bucket(tt.key, tt.salt, tt.userId), "%s:%s:%d", tt.key, tt.salt, tt.userId)
+	}
+}
+
+func TestVariant(t *testing.T) {
+	tests := []struct {
+		userId  int
+		weights []int
+		want    int
+	}{
+		// Бакеты варианта: 5 -> 1899, 6 -> 9263, 7 -> 8286
+		{5, []int{50, 50}, 0},
+		{6, []int{50, 50}, 1},
+		{5, []int{10, 20, 70}, 1},
+		{7, []int{10, 20, 70}, 2},
+		{7, []int{82, 1, 17}, 1},
+		{7, []int{83, 17}, 0},
+		{6, []int{1}, 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Variant("EXP", "salt", tt.userId, tt.weights), "user %d, weights %v", tt.userId, tt.weights)
+	}
+}
+
+func TestPercentageToBuckets(t *testing.T) {
+	tests := []struct {
+		percentage float64
+		want       int
+	}{
+		{0, 0},
+		{0.01, 1},
+		{0.004, 0},
+		{0.005, 1},
+		{0.5, 50},
+		{12.34, 1234},
+		{33.333, 3333},
+		{99.999, 10000},
+		{100, BucketsNum},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, PercentageToBuckets(tt.percentage), "%v%%", tt.percentage)
+	}
+}
+
+func TestSQL(t *testing.T) {
+	assert.Equal(t,
+		"(('x' || substr(md5(seg.id || ':' || seg.salt || ':' || u.id::text), 1, 8))::bit(32)::bigint % 10000)",
+		SQL("seg.id", "seg.salt", "u.id"))
+}
+
+func TestHoldout(t *testing.T) {
+	// Бакеты контрольной группы с солью global_holdout: 1 -> 2935, 5 -> 1746, 7 -> 5819
+	holdout := NewHoldout(20, "global_holdout")
+	assert.Equal(t, 2000, holdout.Buckets)
+	assert.Equal(t, float64(20), holdout.Percentage())
+
+	assert.True(t, holdout.Contains(5))
+	assert.False(t, holdout.Contains(1))
+	assert.False(t, holdout.Contains(7))
+	assert.True(t, NewHoldout(58.2, "global_holdout").Contains(7))
+	assert.False(t, NewHoldout(58.19, "global_holdout").Contains(7))
+
+	// С другой солью состав группы другой: 1 -> 8876, 5 -> 2498
+	other := NewHoldout(25, "other")
+	assert.True(t, other.Contains(5))
+	assert.False(t, other.Contains(1))
+
+	cond, args := holdout.SQL("u.id", []any{"segment"})
+	assert.Equal(t, "((('x' || substr(md5($2::text || ':' || $3::text || ':' || u.id::text), 1, 8))::bit(32)::bigint % 10000) < 2000)", cond)
+	assert.Equal(t, []any{"segment", "global_holdout", "global_holdout"}, args)
+}
+
+func TestDisabledHoldout(t *testing.T) {
+	holdout := NewHoldout(0, "global_holdout")
+
+	for userId := 0; userId < 100; userId++ {
+		assert.False(t, holdout.Contains(userId))
+	}
+
+	cond, args := holdout.SQL("u.id", nil)
+	assert.Equal(t, "FALSE", cond)
+	assert.Empty(t, args)
+}
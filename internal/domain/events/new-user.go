@@ -2,5 +2,6 @@ package events
 
 // NewUserEvent - event создания пользователя
 type NewUserEvent struct {
-	ID int `json:"id"`
+	ID         int            `json:"id"`
+	Attributes map[string]any `json:"attributes"`
 }
@@ -0,0 +1,449 @@
+/*
+Package jsonschema - проверка JSON-документов по подмножеству JSON Schema для параметров сегментов.
+
+Поддерживаются ключевые слова type, enum, const, properties, required, additionalProperties, items,
+minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+а также схемы true и false. Аннотации ($schema, $id, $comment, title, description, default, examples) пропускаются.
+Остальные ключевые слова, в том числе $ref, не поддерживаются: схема с ними не компилируется,
+чтобы проверка не была тише, чем ожидает автор схемы
+*/
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// maxSchemaDepth - максимальная вложенность схемы
+const maxSchemaDepth = 32
+
+// SchemaError - ошибка в самой схеме: путь до подсхемы и описание
+type SchemaError struct {
+	Path string
+	Msg  string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("schema %s: %s", e.Path, e.Msg)
+}
+
+// ValidationError - несоответствие документа схеме: путь до значения в документе и описание
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// annotations - ключевые слова, которые не влияют на проверку
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "default": true, "examples": true,
+}
+
+// types - допустимые значения ключевого слова type
+var types = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// Schema - скомпилированная схема. Пустая схема и схема true пропускают любой документ
+type Schema struct {
+	reject bool // схема false
+
+	types    []string
+	enum     []any
+	constVal *any
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+
+	items    *Schema
+	minItems *int
+	maxItems *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+// Compile - разобрать и проверить схему src
+func Compile(src []byte) (*Schema, error) {
+	doc, err := decode(src)
+	if err != nil {
+		return nil, &SchemaError{Path: "$", Msg: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	return compile(doc, "$", 0)
+}
+
+// Validate - проверить, что JSON-документ doc соответствует схеме
+func (s *Schema) Validate(doc []byte) error {
+	v, err := decode(doc)
+	if err != nil {
+		return &ValidationError{Path: "$", Msg: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	return s.validate(v, "$")
+}
+
+// decode - разобрать ровно одно JSON-значение
+func decode(src []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	return v, nil
+}
+
+func compile(doc any, path string, depth int) (*Schema, error) {
+	if depth > maxSchemaDepth {
+		return nil, &SchemaError{Path: path, Msg: fmt.Sprintf("schema is nested deeper than %d levels", maxSchemaDepth)}
+	}
+
+	if b, ok := doc.(bool); ok {
+		return &Schema{reject: !b}, nil
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, &SchemaError{Path: path, Msg: "schema must be an object or a boolean"}
+	}
+
+	s := &Schema{}
+
+	// Ключи обходятся по порядку, чтобы при нескольких ошибках сообщалась одна и та же
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := s.compileKeyword(key, obj[key], path, depth); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// compileKeyword - разобрать одно ключевое слово схемы
+func (s *Schema) compileKeyword(key string, val any, path string, depth int) error {
+	kwPath := path + "." + key
+	var err error
+
+	switch key {
+	case "type":
+		s.types, err = compileTypes(val, kwPath)
+	case "enum":
+		list, ok := val.([]any)
+		if !ok || len(list) == 0 {
+			return &SchemaError{Path: kwPath, Msg: "must be a non-empty array"}
+		}
+		s.enum = list
+	case "const":
+		s.constVal = &val
+	case "properties":
+		props, ok := val.(map[string]any)
+		if !ok {
+			return &SchemaError{Path: kwPath, Msg: "must be an object"}
+		}
+
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, kwPath+"."+name, depth+1); err != nil {
+				return err
+			}
+		}
+	case "required":
+		s.required, err = compileStrings(val, kwPath)
+	case "additionalProperties":
+		s.additionalProperties, err = compile(val, kwPath, depth+1)
+	case "items":
+		s.items, err = compile(val, kwPath, depth+1)
+	case "minItems":
+		s.minItems, err = compileCount(val, kwPath)
+	case "maxItems":
+		s.maxItems, err = compileCount(val, kwPath)
+	case "minLength":
+		s.minLength, err = compileCount(val, kwPath)
+	case "maxLength":
+		s.maxLength, err = compileCount(val, kwPath)
+	case "pattern":
+		src, ok := val.(string)
+		if !ok {
+			return &SchemaError{Path: kwPath, Msg: "must be a string"}
+		}
+
+		if s.pattern, err = regexp.Compile(src); err != nil {
+			return &SchemaError{Path: kwPath, Msg: fmt.Sprintf("invalid pattern: %v", err)}
+		}
+	case "minimum":
+		s.minimum, err = compileNumber(val, kwPath)
+	case "maximum":
+		s.maximum, err = compileNumber(val, kwPath)
+	case "exclusiveMinimum":
+		s.exclusiveMinimum, err = compileNumber(val, kwPath)
+	case "exclusiveMaximum":
+		s.exclusiveMaximum, err = compileNumber(val, kwPath)
+	default:
+		if !annotations[key] {
+			return &SchemaError{Path: kwPath, Msg: "unsupported keyword"}
+		}
+	}
+
+	return err
+}
+
+func compileTypes(val any, path string) ([]string, error) {
+	if name, ok := val.(string); ok {
+		val = []any{name}
+	}
+
+	names, err := compileStrings(val, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, &SchemaError{Path: path, Msg: "must not be empty"}
+	}
+
+	for _, name := range names {
+		if !types[name] {
+			return nil, &SchemaError{Path: path, Msg: fmt.Sprintf("unknown type %q", name)}
+		}
+	}
+
+	return names, nil
+}
+
+func compileStrings(val any, path string) ([]string, error) {
+	list, ok := val.([]any)
+	if !ok {
+		return nil, &SchemaError{Path: path, Msg: "must be an array of strings"}
+	}
+
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			return nil, &SchemaError{Path: path, Msg: "must be an array of strings"}
+		}
+		res = append(res, str)
+	}
+
+	return res, nil
+}
+
+func compileCount(val any, path string) (*int, error) {
+	num, ok := val.(float64)
+	if !ok || num < 0 || num != math.Trunc(num) || num > math.MaxInt32 {
+		return nil, &SchemaError{Path: path, Msg: "must be a non-negative integer"}
+	}
+
+	res := int(num)
+	return &res, nil
+}
+
+func compileNumber(val any, path string) (*float64, error) {
+	num, ok := val.(float64)
+	if !ok {
+		return nil, &SchemaError{Path: path, Msg: "must be a number"}
+	}
+
+	return &num, nil
+}
+
+func (s *Schema) validate(v any, path string) error {
+	if s.reject {
+		return &ValidationError{Path: path, Msg: "value is not allowed"}
+	}
+
+	if len(s.types) > 0 && !s.matchesType(v) {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected %s, got %s", joinTypes(s.types), typeOf(v))}
+	}
+
+	if s.enum != nil && !containsValue(s.enum, v) {
+		return &ValidationError{Path: path, Msg: "value is not one of the allowed values"}
+	}
+
+	if s.constVal != nil && !reflect.DeepEqual(*s.constVal, v) {
+		return &ValidationError{Path: path, Msg: "value does not match const"}
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		return s.validateObject(val, path)
+	case []any:
+		return s.validateArray(val, path)
+	case string:
+		return s.validateString(val, path)
+	case float64:
+		return s.validateNumber(val, path)
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(obj map[string]any, path string) error {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			return &ValidationError{Path: path, Msg: fmt.Sprintf("missing required property %q", name)}
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sub, ok := s.properties[name]
+		if !ok {
+			sub = s.additionalProperties
+		}
+
+		if sub == nil {
+			continue
+		}
+
+		if err := sub.validate(obj[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateArray(arr []any, path string) error {
+	if s.minItems != nil && len(arr) < *s.minItems {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected at least %d items", *s.minItems)}
+	}
+
+	if s.maxItems != nil && len(arr) > *s.maxItems {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected at most %d items", *s.maxItems)}
+	}
+
+	if s.items == nil {
+		return nil
+	}
+
+	for i, item := range arr {
+		if err := s.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateString(str string, path string) error {
+	length := utf8.RuneCountInString(str)
+
+	if s.minLength != nil && length < *s.minLength {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected at least %d characters", *s.minLength)}
+	}
+
+	if s.maxLength != nil && length > *s.maxLength {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected at most %d characters", *s.maxLength)}
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("does not match pattern %q", s.pattern.String())}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateNumber(num float64, path string) error {
+	if s.minimum != nil && num < *s.minimum {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("must be >= %v", *s.minimum)}
+	}
+
+	if s.maximum != nil && num > *s.maximum {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("must be <= %v", *s.maximum)}
+	}
+
+	if s.exclusiveMinimum != nil && num <= *s.exclusiveMinimum {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("must be > %v", *s.exclusiveMinimum)}
+	}
+
+	if s.exclusiveMaximum != nil && num >= *s.exclusiveMaximum {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("must be < %v", *s.exclusiveMaximum)}
+	}
+
+	return nil
+}
+
+// matchesType - проверка, что v подходит хотя бы под один из типов схемы. integer - число без дробной части
+func (s *Schema) matchesType(v any) bool {
+	actual := typeOf(v)
+
+	for _, t := range s.types {
+		if t == actual || (t == "integer" && actual == "number" && v.(float64) == math.Trunc(v.(float64))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// typeOf - тип JSON-значения в терминах JSON Schema
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	}
+
+	return "unknown"
+}
+
+func joinTypes(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+
+	return fmt.Sprintf("one of %v", names)
+}
+
+func containsValue(list []any, v any) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,153 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		doc     string
+		wantErr string
+	}{
+		// type
+		{"type string", `{"type": "string"}`, `"a"`, ""},
+		{"type mismatch", `{"type": "string"}`, `1`, "$: expected string, got number"},
+		{"type list", `{"type": ["string", "null"]}`, `null`, ""},
+		{"type list mismatch", `{"type": ["string", "null"]}`, `true`, "$: expected one of [string null], got boolean"},
+		{"integer", `{"type": "integer"}`, `3`, ""},
+		{"integer with zero fraction", `{"type": "integer"}`, `3.0`, ""},
+		{"integer with fraction", `{"type": "integer"}`, `3.5`, "$: expected integer, got number"},
+		{"number accepts integer", `{"type": "number"}`, `3`, ""},
+		{"object", `{"type": "object"}`, `[]`, "$: expected object, got array"},
+
+		// enum / const
+		{"enum", `{"enum": ["a", 1, null]}`, `1`, ""},
+		{"enum null", `{"enum": ["a", 1, null]}`, `null`, ""},
+		{"enum mismatch", `{"enum": ["a", 1]}`, `"b"`, "$: value is not one of the allowed values"},
+		{"enum object", `{"enum": [{"a": [1, 2]}]}`, `{"a": [1, 2]}`, ""},
+		{"const", `{"const": {"a": 1}}`, `{"a": 1}`, ""},
+		{"const mismatch", `{"const": {"a": 1}}`, `{"a": 2}`, "$: value does not match const"},
+
+		// required / properties / additionalProperties
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1, "b": null}`, ""},
+		{"required missing", `{"required": ["a", "b"]}`, `{"a": 1}`, `$: missing required property "b"`},
+		{"required ignores non-objects", `{"required": ["a"]}`, `"a"`, ""},
+		{"property", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, "$.a: expected string, got number"},
+		{"nested property", `{"properties": {"a": {"properties": {"b": {"minimum": 0}}}}}`, `{"a": {"b": -1}}`, "$.a.b: must be >= 0"},
+		{"additional allowed", `{"properties": {"a": {}}}`, `{"b": 1}`, ""},
+		{"additional false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 1}`, "$.b: value is not allowed"},
+		{"additional schema", `{"additionalProperties": {"type": "integer"}}`, `{"a": 1, "b": "x"}`, "$.b: expected integer, got string"},
+		{"first error by name", `{"additionalProperties": false}`, `{"b": 1, "a": 1}`, "$.a: value is not allowed"},
+
+		// items / minItems / maxItems
+		{"items", `{"items": {"type": "string"}}`, `["a", 1]`, "$[1]: expected string, got number"},
+		{"minItems", `{"minItems": 2}`, `[1]`, "$: expected at least 2 items"},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, "$: expected at most 1 items"},
+		{"items bounds ok", `{"minItems": 1, "maxItems": 2}`, `[1, 2]`, ""},
+
+		// строки
+		{"minLength in characters", `{"minLength": 3}`, `"абв"`, ""},
+		{"minLength", `{"minLength": 3}`, `"ab"`, "$: expected at least 3 characters"},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, "$: expected at most 2 characters"},
+		{"pattern", `{"pattern": "^#[0-9a-f]{6}$"}`, `"#ff00aa"`, ""},
+		{"pattern mismatch", `{"pattern": "^#[0-9a-f]{6}$"}`, `"red"`, `$: does not match pattern "^#[0-9a-f]{6}$"`},
+		{"pattern not anchored", `{"pattern": "ed"}`, `"red"`, ""},
+		{"string keywords ignore numbers", `{"minLength": 5, "pattern": "^a"}`, `1`, ""},
+
+		// числа
+		{"minimum inclusive", `{"minimum": 1}`, `1`, ""},
+		{"minimum", `{"minimum": 1}`, `0.5`, "$: must be >= 1"},
+		{"maximum inclusive", `{"maximum": 10}`, `10`, ""},
+		{"maximum", `{"maximum": 10}`, `10.5`, "$: must be <= 10"},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, "$: must be > 1"},
+		{"exclusiveMinimum ok", `{"exclusiveMinimum": 1}`, `1.01`, ""},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 10}`, `10`, "$: must be < 10"},
+		{"exclusiveMaximum ok", `{"exclusiveMaximum": 10}`, `-10`, ""},
+		{"number keywords ignore strings", `{"minimum": 5}`, `"1"`, ""},
+
+		// схемы true и false, пустая схема, аннотации
+		{"true schema", `true`, `{"a": [1]}`, ""},
+		{"false schema", `false`, `null`, "$: value is not allowed"},
+		{"empty schema", `{}`, `"anything"`, ""},
+		{"annotations", `{"$schema": "x", "$id": "y", "$comment": "c", "title": "t", "description": "d", "default": 1, "examples": [1]}`, `2`, ""},
+
+		// документ
+		{"invalid document", `{}`, `{"a": }`, "$: invalid JSON: invalid character '}' looking for beginning of value"},
+		{"trailing data", `{}`, `1 2`, "$: invalid JSON: unexpected data after JSON value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			require.NoError(t, err)
+
+			err = schema.Validate([]byte(tt.doc))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"invalid JSON", `{"type": }`, "schema $: invalid JSON: invalid character '}' looking for beginning of value"},
+		{"not an object", `"string"`, "schema $: schema must be an object or a boolean"},
+		{"ref", `{"$ref": "#/definitions/flag"}`, "schema $.$ref: unsupported keyword"},
+		{"nested ref", `{"properties": {"a": {"$ref": "#"}}}`, "schema $.properties.a.$ref: unsupported keyword"},
+		{"unknown keyword", `{"oneOf": [{}]}`, "schema $.oneOf: unsupported keyword"},
+		{"unknown type", `{"type": "float"}`, `schema $.type: unknown type "float"`},
+		{"empty type list", `{"type": []}`, "schema $.type: must not be empty"},
+		{"type not a string", `{"type": 1}`, "schema $.type: must be an array of strings"},
+		{"empty enum", `{"enum": []}`, "schema $.enum: must be a non-empty array"},
+		{"required not strings", `{"required": [1]}`, "schema $.required: must be an array of strings"},
+		{"properties not an object", `{"properties": []}`, "schema $.properties: must be an object"},
+		{"negative minItems", `{"minItems": -1}`, "schema $.minItems: must be a non-negative integer"},
+		{"fractional maxLength", `{"maxLength": 1.5}`, "schema $.maxLength: must be a non-negative integer"},
+		{"minimum not a number", `{"minimum": "1"}`, "schema $.minimum: must be a number"},
+		{"pattern not a string", `{"pattern": 1}`, "schema $.pattern: must be a string"},
+		{"invalid pattern", `{"pattern": "("}`, "schema $.pattern: invalid pattern: error parsing regexp: missing closing ): `(`"},
+		{"first error by key", `{"zzz": 1, "aaa": 1}`, "schema $.aaa: unsupported keyword"},
+		{"additionalProperties not a schema", `{"additionalProperties": 1}`, "schema $.additionalProperties: schema must be an object or a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			require.Error(t, err)
+			assert.Nil(t, schema)
+
+			var schemaErr *SchemaError
+			require.ErrorAs(t, err, &schemaErr)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+func TestCompileDepthLimit(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat(`{"items": `, depth) + "{}" + strings.Repeat("}", depth)
+	}
+
+	_, err := Compile([]byte(nested(maxSchemaDepth)))
+	require.NoError(t, err)
+
+	_, err = Compile([]byte(nested(maxSchemaDepth + 1)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "schema is nested deeper than 32 levels")
+}
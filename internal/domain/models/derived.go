@@ -0,0 +1,7 @@
+package models
+
+// Режимы производного сегмента
+const (
+	DerivedModeSnapshot = "snapshot" // состав вычисляется один раз при создании
+	DerivedModeLive     = "live"     // состав пересчитывается при изменении исходных сегментов
+)
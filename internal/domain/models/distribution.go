@@ -0,0 +1,84 @@
+package models
+
+// Источники членства пользователя в сегменте
+const (
+	MemberSourceDistribution = "distribution"
+	MemberSourceManual       = "manual"
+	MemberSourceDerived      = "derived" // пересчитывается из выражения производного сегмента
+)
+
+/*
+	DistributionTarget - целевой размер сегмента при распространении.
+
+Либо доля пользователей в процентах с точностью до сотых, либо точное число пользователей (ByCount)
+*/
+type DistributionTarget struct {
+	Percentage float64 `json:"percentage"`
+	Count      int64   `json:"count"`
+	ByCount    bool    `json:"by_count"`
+}
+
+// ShardDistribution - изменения состава сегмента на одном шарде при распространении
+type ShardDistribution struct {
+	ShardId int   `json:"shard_id"`
+	Added   int64 `json:"added"`
+	Removed int64 `json:"removed"`
+	// Requested - сколько пользователей шарда запрошено при распространении по количеству
+	Requested int64 `json:"requested"`
+	// Assigned - сколько пользователей шарда в сегменте по распространению после него
+	Assigned int64 `json:"assigned"`
+	// Rejected - сколько пользователей шарда из целевой выборки не добавлено из-за лимита участников сегмента
+	Rejected int64 `json:"rejected"`
+}
+
+// DistributionResult - результат распространения сегмента по всем шардам
+type DistributionResult struct {
+	Id     string              `json:"id"`
+	Shards []ShardDistribution `json:"shards"`
+}
+
+// Added - сколько всего пользователей добавлено в сегмент
+func (dr DistributionResult) Added() int64 {
+	var res int64
+	for _, sh := range dr.Shards {
+		res += sh.Added
+	}
+
+	return res
+}
+
+// Assigned - сколько всего пользователей в сегменте по распространению
+func (dr DistributionResult) Assigned() int64 {
+	var res int64
+	for _, sh := range dr.Shards {
+		res += sh.Assigned
+	}
+
+	return res
+}
+
+// Rejected - сколько всего пользователей не добавлено из-за лимита участников сегмента
+func (dr DistributionResult) Rejected() int64 {
+	var res int64
+	for _, sh := range dr.Shards {
+		res += sh.Rejected
+	}
+
+	return res
+}
+
+// Removed - сколько всего пользователей удалено из сегмента
+func (dr DistributionResult) Removed() int64 {
+	var res int64
+	for _, sh := range dr.Shards {
+		res += sh.Removed
+	}
+
+	return res
+}
+
+// DistributionPreview - результат распространения сегмента без записи изменений и примеры пользователей из целевой выборки
+type DistributionPreview struct {
+	DistributionResult
+	SampleUserIds []int `json:"sample_user_ids"`
+}
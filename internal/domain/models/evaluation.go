@@ -0,0 +1,46 @@
+package models
+
+// Причины решения о членстве пользователя в сегменте при оценке в памяти
+const (
+	EvaluationReasonTargeted       = "targeted"        // пользователь попадает в целевую выборку распространения
+	EvaluationReasonOverride       = "override"        // решение задано переопределением пользователя
+	EvaluationReasonInactive       = "inactive"        // сегмент выключен, еще не начал действовать или истек
+	EvaluationReasonHoldout        = "holdout"         // пользователь в глобальной контрольной группе
+	EvaluationReasonNotDistributed = "not_distributed" // сегмент еще не распространялся
+	EvaluationReasonNoAutoEnroll   = "no_auto_enroll"  // сегмент распространен без автодобавления, новые пользователи в него не попадают
+	EvaluationReasonCountTarget    = "count_target"    // сегмент распространен по количеству, выборка зависит от других пользователей
+	EvaluationReasonBucket         = "bucket"          // бакет пользователя за порогом распространения
+	EvaluationReasonRule           = "rule"            // атрибуты пользователя не подходят под условие распространения
+	EvaluationReasonFull           = "full"            // сегмент заполнен до лимита участников
+	EvaluationReasonLayer          = "layer"           // пользователь занят другим сегментом слоя
+	EvaluationReasonDerived        = "derived"         // членство вычислено по выражению производного сегмента
+)
+
+/*
+	SegmentTargeting - сегмент с настройками распространения, по которым членство пользователя решается в памяти.
+
+Override и OverrideExpiresAt сегмента - переопределение оцениваемого пользователя, если оно есть
+*/
+type SegmentTargeting struct {
+	Segment
+	BucketKey     string `json:"bucket_key"`
+	TargetBuckets int    `json:"target_buckets"`
+	// TargetCount - целевое число пользователей, если сегмент распространен по количеству
+	TargetCount *int64 `json:"target_count,omitempty"`
+	Rule        string `json:"rule,omitempty"`
+	AutoEnroll  bool   `json:"auto_enroll"`
+	// Members - число участников на всех шардах, считается только для сегментов с лимитом участников
+	Members     int64  `json:"members,omitempty"`
+	DerivedExpr string `json:"derived_expr,omitempty"`
+	DerivedMode string `json:"derived_mode,omitempty"`
+}
+
+// Evaluation - решение о членстве пользователя в сегменте и его причина
+type Evaluation struct {
+	SegmentId string `json:"segment_id"`
+	Member    bool   `json:"member"`
+	Variant   string `json:"variant,omitempty"`
+	Reason    string `json:"reason"`
+	// Detail - пояснение к причине: занявший слой сегмент, действие переопределения и т.п.
+	Detail string `json:"detail,omitempty"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Действия над членством пользователя в сегменте
+const (
+	HistoryActionAdd    = "add"
+	HistoryActionRemove = "remove"
+	HistoryActionRename = "rename"
+)
+
+// Причины изменения членства пользователя в сегменте
+const (
+	HistoryReasonDistribution    = "distribution"
+	HistoryReasonManual          = "manual"
+	HistoryReasonSegmentDeletion = "segment_deletion"
+	HistoryReasonUserDeletion    = "user_deletion"
+	HistoryReasonAutoEnrollment  = "auto_enrollment"
+	HistoryReasonDerivation      = "derivation"
+	HistoryReasonSegmentRename   = "segment_rename"
+)
+
+// HistoryEntry - запись истории членства пользователя в сегменте
+type HistoryEntry struct {
+	UserId    int    `json:"user_id"`
+	SegmentId string `json:"segment_id"`
+	// PreviousSegmentId - прежний id сегмента в записи о его переименовании
+	PreviousSegmentId string    `json:"previous_segment_id,omitempty"`
+	Action            string    `json:"action"`
+	Reason            string    `json:"reason"`
+	CreatedAt         time.Time `json:"created_at"`
+}
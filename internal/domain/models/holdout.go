@@ -0,0 +1,14 @@
+package models
+
+// ShardHoldout - размер глобальной контрольной группы на одном шарде
+type ShardHoldout struct {
+	ShardId      int   `json:"shard_id"`
+	UsersNum     int64 `json:"users_num"`
+	HoldoutUsers int64 `json:"holdout_users"`
+}
+
+// HoldoutInfo - настройки и размер глобальной контрольной группы
+type HoldoutInfo struct {
+	Percentage float64        `json:"percentage"`
+	Shards     []ShardHoldout `json:"shards"`
+}
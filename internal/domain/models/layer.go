@@ -0,0 +1,7 @@
+package models
+
+// Layer - слой взаимоисключающих сегментов: пользователь состоит не более чем в одном сегменте слоя
+type Layer struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+}
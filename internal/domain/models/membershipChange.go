@@ -0,0 +1,27 @@
+package models
+
+import "sort"
+
+// MembershipChange - результат ручного добавления или удаления пользователей из сегмента
+type MembershipChange struct {
+	Changed   []int `json:"changed"`   // пользователи, которые были добавлены или удалены
+	Unknown   []int `json:"unknown"`   // пользователи, которых нет в системе
+	Unchanged []int `json:"unchanged"` // уже состоявшие в сегменте при добавлении или не состоявшие при удалении
+	Conflicts []int `json:"conflicts"` // при добавлении: уже занятые другим сегментом того же слоя
+	Rejected  []int `json:"rejected"`  // при добавлении: не добавленные, потому что сегмент заполнен до лимита
+}
+
+// Sort - упорядочить все списки по возрастанию id
+func (mc *MembershipChange) Sort() {
+	sort.Ints(mc.Changed)
+	sort.Ints(mc.Unknown)
+	sort.Ints(mc.Unchanged)
+	sort.Ints(mc.Conflicts)
+	sort.Ints(mc.Rejected)
+}
+
+// SegmentMember - участник сегмента и его вариант эксперимента, если у сегмента есть варианты
+type SegmentMember struct {
+	UserId  int    `json:"user_id"`
+	Variant string `json:"variant,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+/*
+	SegmentOverlap - попарные пересечения сегментов.
+
+Counts[i][j] - число пользователей, состоящих и в Ids[i], и в Ids[j]; на диагонали - размеры сегментов
+*/
+type SegmentOverlap struct {
+	Ids    []string  `json:"ids"`
+	Counts [][]int64 `json:"counts"`
+	// FailedShards - шарды, которые не ответили: без них пересечения занижены
+	FailedShards []int `json:"failed_shards"`
+}
+
+// Jaccard - мера Жаккара сегментов Ids[i] и Ids[j]: доля общих пользователей среди состоящих хотя бы в одном из них
+func (so SegmentOverlap) Jaccard(i, j int) float64 {
+	union := so.Counts[i][i] + so.Counts[j][j] - so.Counts[i][j]
+	if union == 0 {
+		return 0
+	}
+
+	return float64(so.Counts[i][j]) / float64(union)
+}
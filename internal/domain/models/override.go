@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Действия принудительного переопределения членства пользователя в сегменте
+const (
+	OverrideInclude = "include" // пользователь считается участником сегмента, даже если не попал в него
+	OverrideExclude = "exclude" // пользователь не считается участником сегмента, даже если попал в него
+)
+
+// Override - принудительное переопределение членства пользователя в сегменте, например для тестировщиков
+type Override struct {
+	UserId    int        `json:"user_id"`
+	SegmentId string     `json:"segment_id"`
+	Action    string     `json:"action"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil - действует бессрочно
+}
+
+// IsValidOverrideAction - проверка, что action - одно из действий переопределения
+func IsValidOverrideAction(action string) bool {
+	return action == OverrideInclude || action == OverrideExclude
+}
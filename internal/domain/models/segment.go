@@ -1,7 +1,69 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// Статусы жизненного цикла сегмента
+const (
+	SegmentStatusDraft    = "draft"    // сегмент готовится к запуску, пользователи его еще не видят
+	SegmentStatusActive   = "active"   // сегмент действует
+	SegmentStatusPaused   = "paused"   // сегмент временно выключен, участники сохраняются
+	SegmentStatusArchived = "archived" // сегмент выключен и заморожен, участники сохраняются
+)
+
 // Segment - Структура для сегмента, на которые делятся пользователи
 type Segment struct {
-	Id          string `json:"id"`
-	Description string `json:"description"`
+	Id          string     `json:"id"`
+	Description string     `json:"description"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Salt        string     `json:"salt,omitempty"` // соль для хэширования пользователей по бакетам
+	Status      string     `json:"status,omitempty"`
+	LayerId     string     `json:"layer_id,omitempty"` // слой взаимоисключающих сегментов, если есть
+	Variants    []Variant  `json:"variants,omitempty"` // варианты эксперимента, задаются при создании
+	Variant     string     `json:"variant,omitempty"`  // вариант, в который попал пользователь
+	// MaxMembers - лимит участников на всех шардах вместе, nil - без лимита
+	MaxMembers *int64 `json:"max_members,omitempty"`
+	// Override и OverrideExpiresAt - переопределение членства пользователя в сегменте, если оно задано
+	Override          string     `json:"override,omitempty"`
+	OverrideExpiresAt *time.Time `json:"override_expires_at,omitempty"`
+	// OverrideOnly - пользователь не состоит в сегменте, сегмент попал в список только из-за переопределения
+	OverrideOnly bool `json:"override_only,omitempty"`
+	// Payload - параметры сегмента для клиентов в JSON. В сегментах пользователя - параметры его варианта, если они заданы
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// PayloadSchema - JSON Schema, по которой проверяются параметры сегмента и его вариантов
+	PayloadSchema json.RawMessage `json:"payload_schema,omitempty"`
+}
+
+// IsActive - проверка, что сегмент включен, уже начал действовать и еще не истек в момент now
+func (s Segment) IsActive(now time.Time) bool {
+	if s.Status != "" && s.Status != SegmentStatusActive {
+		return false
+	}
+
+	if s.StartsAt != nil && now.Before(*s.StartsAt) {
+		return false
+	}
+
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return false
+	}
+
+	return true
+}
+
+/*
+	IsMemberAt - проверка, что пользователь состоит в сегменте в момент now с учетом переопределения.
+
+Истекшее переопределение не учитывается: остается обычное членство. Если IsMemberAt вернула true
+для сегмента с OverrideOnly, членство пришло из переопределения
+*/
+func (s Segment) IsMemberAt(now time.Time) bool {
+	if s.Override != "" && (s.OverrideExpiresAt == nil || now.Before(*s.OverrideExpiresAt)) {
+		return s.Override == OverrideInclude
+	}
+
+	return !s.OverrideOnly
 }
@@ -1,8 +1,46 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // SegmentInfo - Структура для статистики сегмента, которая получается при запросе GetSegmentInfo
 type SegmentInfo struct {
-	Id          string `json:"id"`
-	Description string `json:"description"`
-	UsersNum    int64  `json:"users_num"`
+	Id          string     `json:"id"`
+	Description string     `json:"description"`
+	UsersNum    int64      `json:"users_num"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Salt        string     `json:"salt"`
+	// TargetBuckets - целевое число бакетов распространения из bucketing.BucketsNum
+	TargetBuckets int    `json:"target_buckets"`
+	Rule          string `json:"rule"`
+	AutoEnroll    bool   `json:"auto_enroll"`
+	Status        string `json:"status"`
+	LayerId       string `json:"layer_id,omitempty"`
+	// LayerFreeUsers - сколько пользователей еще не заняты ни одним сегментом слоя
+	LayerFreeUsers  int64         `json:"layer_free_users,omitempty"`
+	LayerTotalUsers int64         `json:"layer_total_users,omitempty"`
+	Variants        []VariantInfo `json:"variants,omitempty"`
+	// DerivedExpr - выражение над сегментами, из которого построен производный сегмент
+	DerivedExpr string `json:"derived_expr,omitempty"`
+	DerivedMode string `json:"derived_mode,omitempty"`
+	// TargetCount - целевое число пользователей, если сегмент распространен по количеству
+	TargetCount *int64 `json:"target_count,omitempty"`
+	// MaxMembers - лимит участников сегмента на всех шардах, nil - без лимита
+	MaxMembers *int64 `json:"max_members,omitempty"`
+	// Payload и PayloadSchema - параметры сегмента для клиентов и их JSON Schema, если заданы
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	PayloadSchema json.RawMessage `json:"payload_schema,omitempty"`
+	// Shards - число участников на каждом ответившем шарде
+	Shards []ShardUsers `json:"shards"`
+	// FailedShards - шарды, которые не ответили: без них числа участников занижены
+	FailedShards []int `json:"failed_shards"`
+}
+
+// ShardUsers - число участников сегмента на одном шарде
+type ShardUsers struct {
+	ShardId  int   `json:"shard_id"`
+	UsersNum int64 `json:"users_num"`
 }
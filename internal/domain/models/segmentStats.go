@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// StatsPoint - число участников сегмента по ежедневному снимку, сложенное по шардам
+type StatsPoint struct {
+	Day      time.Time `json:"day"`
+	UsersNum int64     `json:"users_num"`
+	// Shards - сколько шардов сделали снимок за этот день. Если меньше числа шардов, UsersNum занижен
+	Shards int `json:"shards"`
+}
+
+// StatsHistory - рост числа участников сегмента по дням
+type StatsHistory struct {
+	Id     string       `json:"id"`
+	Points []StatsPoint `json:"points"`
+	// FailedShards - шарды, которые не ответили: их снимки не учтены
+	FailedShards []int `json:"failed_shards"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// SegmentsFilter - условия выборки каталога сегментов
+type SegmentsFilter struct {
+	IdPrefix            string // id сегмента начинается с этой строки
+	DescriptionContains string // описание содержит эту строку без учета регистра
+	After               string // курсор: выдаются сегменты с id строго больше
+	Limit               int
+	WithCounts          bool // посчитать число участников каждого сегмента по всем шардам
+}
+
+// SegmentsPage - страница каталога сегментов, упорядоченного по id
+type SegmentsPage struct {
+	Segments []SegmentInfo `json:"segments"`
+	// Next - id последнего сегмента страницы, если за ним есть еще сегменты, иначе пусто
+	Next string `json:"next,omitempty"`
+}
@@ -1,6 +1,135 @@
 package models
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Известные атрибуты пользователя. Их значения приводятся к одному виду, чтобы условия распространения сравнивали их одинаково
+const (
+	AttributeCountry    = "country"     // код страны ISO 3166-1 alpha-2 в верхнем регистре: "RU"
+	AttributePlatform   = "platform"    // платформа в нижнем регистре: "ios", "android", "web"
+	AttributeSignupDate = "signup_date" // дата регистрации в формате "2006-01-02", сравнивается как строка
+	AttributePlan       = "plan"        // тариф в нижнем регистре: "free", "pro"
+)
+
 // User - структура для пользователя
 type User struct {
-	Id int `json:"id"`
+	Id         int            `json:"id"`
+	Attributes map[string]any `json:"attributes,omitempty"` // атрибуты для условий распространения: страна, платформа, тариф и т.д.
+}
+
+// UserIdsToInt64 - перевод id пользователей в []int64 для ответов grpc и массивов postgres
+func UserIdsToInt64(ids []int) []int64 {
+	res := make([]int64, len(ids))
+
+	for i, id := range ids {
+		res[i] = int64(id)
+	}
+
+	return res
+}
+
+/*
+	NormalizeAttributes - проверить известные атрибуты пользователя и привести их к одному виду.
+
+Остальные атрибуты возвращаются без изменений. Исходный map не меняется
+*/
+func NormalizeAttributes(attrs map[string]any) (map[string]any, error) {
+	res := make(map[string]any, len(attrs))
+
+	for key, val := range attrs {
+		res[key] = val
+	}
+
+	for key := range attributeNormalizers {
+		val, ok := attrs[key]
+		if !ok {
+			continue
+		}
+
+		str, isStr := val.(string)
+		if !isStr {
+			return nil, fmt.Errorf("attribute %s must be a string, got %T", key, val)
+		}
+
+		norm, err := NormalizeAttribute(key, str)
+		if err != nil {
+			return nil, err
+		}
+
+		res[key] = norm
+	}
+
+	return res, nil
+}
+
+// IsKnownAttribute - проверка, что значения атрибута key приводятся к одному виду
+func IsKnownAttribute(key string) bool {
+	_, ok := attributeNormalizers[key]
+	return ok
+}
+
+// NormalizeAttribute - привести значение атрибута key к виду, в котором оно хранится. Значения остальных атрибутов не меняются
+func NormalizeAttribute(key, val string) (string, error) {
+	normalize, ok := attributeNormalizers[key]
+	if !ok {
+		return val, nil
+	}
+
+	norm, err := normalize(strings.TrimSpace(val))
+	if err != nil {
+		return "", fmt.Errorf("attribute %s: %w", key, err)
+	}
+
+	return norm, nil
+}
+
+// attributeNormalizers - приведение значений известных атрибутов
+var attributeNormalizers = map[string]func(string) (string, error){
+	AttributeCountry:    normalizeCountry,
+	AttributePlatform:   normalizeName,
+	AttributeSignupDate: normalizeDate,
+	AttributePlan:       normalizeName,
+}
+
+func normalizeCountry(v string) (string, error) {
+	if len(v) != 2 || !isLatin(v) {
+		return "", fmt.Errorf("%q is not a two-letter country code", v)
+	}
+
+	return strings.ToUpper(v), nil
+}
+
+func normalizeName(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("value is empty")
+	}
+
+	return strings.ToLower(v), nil
+}
+
+// normalizeDate - дата или время в RFC 3339, приведенные к дате в UTC
+func normalizeDate(v string) (string, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t.Format(time.DateOnly), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a date in format YYYY-MM-DD", v)
+	}
+
+	return t.UTC().Format(time.DateOnly), nil
+}
+
+func isLatin(v string) bool {
+	for _, r := range v {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+
+	return true
 }
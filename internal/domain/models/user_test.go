@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAttributes(t *testing.T) {
+	attrs := map[string]any{
+		"country":     " ru ",
+		"platform":    "iOS",
+		"signup_date": "2024-03-01T23:30:00-02:00",
+		"plan":        "Pro",
+		"age":         float64(30),
+	}
+
+	res, err := NormalizeAttributes(attrs)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"country":     "RU",
+		"platform":    "ios",
+		"signup_date": "2024-03-02",
+		"plan":        "pro",
+		"age":         float64(30),
+	}, res)
+
+	// Исходные атрибуты не меняются
+	assert.Equal(t, " ru ", attrs["country"])
+
+	res, err = NormalizeAttributes(nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestNormalizeAttributesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]any
+		want  string
+	}{
+		{"country not a string", map[string]any{"country": float64(7)}, "attribute country must be a string, got float64"},
+		{"country too long", map[string]any{"country": "RUS"}, `attribute country: "RUS" is not a two-letter country code`},
+		{"country not latin", map[string]any{"country": "РФ"}, `attribute country: "РФ" is not a two-letter country code`},
+		{"empty platform", map[string]any{"platform": " "}, "attribute platform: value is empty"},
+		{"plan is null", map[string]any{"plan": nil}, "attribute plan must be a string, got <nil>"},
+		{"bad date", map[string]any{"signup_date": "01.03.2024"}, `attribute signup_date: "01.03.2024" is not a date in format YYYY-MM-DD`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NormalizeAttributes(tt.attrs)
+			require.Error(t, err)
+			assert.Equal(t, tt.want, err.Error())
+		})
+	}
+}
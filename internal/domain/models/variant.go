@@ -0,0 +1,21 @@
+package models
+
+import "encoding/json"
+
+// Variant - вариант эксперимента с весом. Пользователь сегмента попадает ровно в один вариант,
+// доли вариантов пропорциональны весам
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	// Payload - параметры варианта, заменяют параметры сегмента для его пользователей
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// VariantInfo - вариант эксперимента и число пользователей в нем
+type VariantInfo struct {
+	Name     string `json:"name"`
+	Weight   int    `json:"weight"`
+	UsersNum int64  `json:"users_num"`
+	// Payload - параметры варианта, если заданы
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
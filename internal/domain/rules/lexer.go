@@ -0,0 +1,177 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind - тип лексемы выражения фильтра
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokTrue
+	tokFalse
+	tokIn
+	tokNot
+	tokAnd
+	tokOr
+	tokBang
+	tokEq
+	tokNeq
+	tokLt
+	tokLte
+	tokGt
+	tokGte
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// token - лексема выражения фильтра с позицией начала в исходной строке
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// keywords - ключевые слова языка фильтров
+var keywords = map[string]tokenKind{
+	"in":    tokIn,
+	"not":   tokNot,
+	"true":  tokTrue,
+	"false": tokFalse,
+}
+
+// lex - разбить выражение фильтра на лексемы
+func lex(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(src)
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, &ParseError{Pos: i, Msg: fmt.Sprintf("unexpected %q, did you mean %q", string(r), string([]rune{r, r}))}
+			}
+
+			kind := tokAnd
+			if r == '|' {
+				kind = tokOr
+			}
+
+			tokens = append(tokens, token{kind: kind, text: string([]rune{r, r}), pos: i})
+			i += 2
+		case r == '=':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, &ParseError{Pos: i, Msg: `unexpected "=", did you mean "=="`}
+			}
+
+			tokens = append(tokens, token{kind: tokEq, text: "==", pos: i})
+			i += 2
+		case r == '!':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, token{kind: tokNeq, text: "!=", pos: i})
+				i += 2
+				continue
+			}
+
+			tokens = append(tokens, token{kind: tokBang, text: "!", pos: i})
+			i++
+		case r == '<' || r == '>':
+			kind, text := tokLt, "<"
+			if r == '>' {
+				kind, text = tokGt, ">"
+			}
+
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, token{kind: kind + 1, text: text + "=", pos: i})
+				i += 2
+				continue
+			}
+
+			tokens = append(tokens, token{kind: kind, text: text, pos: i})
+			i++
+		case r == '"':
+			start := i
+			var sb strings.Builder
+			i++
+
+			for {
+				if i >= len(runes) {
+					return nil, &ParseError{Pos: start, Msg: "unterminated string"}
+				}
+
+				if runes[i] == '"' {
+					i++
+					break
+				}
+
+				if runes[i] == '\\' {
+					if i+1 >= len(runes) || (runes[i+1] != '"' && runes[i+1] != '\\') {
+						return nil, &ParseError{Pos: i, Msg: `only \" and \\ escapes are supported`}
+					}
+					i++
+				}
+
+				sb.WriteRune(runes[i])
+				i++
+			}
+
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			i++
+
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+
+			text := string(runes[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, &ParseError{Pos: start, Msg: fmt.Sprintf("invalid number %q", text)}
+			}
+
+			tokens = append(tokens, token{kind: tokNumber, text: text, pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+
+			text := string(runes[start:i])
+			kind, ok := keywords[text]
+			if !ok {
+				kind = tokIdent
+			}
+
+			tokens = append(tokens, token{kind: kind, text: text, pos: start})
+		default:
+			return nil, &ParseError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", string(r))}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(runes)})
+
+	return tokens, nil
+}
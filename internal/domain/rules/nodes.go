@@ -0,0 +1,231 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// node - узел дерева выражения фильтра
+type node interface {
+	eval(attrs map[string]any) bool
+	sql(b *sqlBuilder) string
+}
+
+// sqlBuilder - накапливает параметры запроса при переводе выражения в SQL
+type sqlBuilder struct {
+	column string
+	args   []any
+}
+
+// arg - добавить параметр и вернуть его плейсхолдер
+func (b *sqlBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// field - обращение к атрибуту attr как к jsonb
+func (b *sqlBuilder) field(attr string) string {
+	return fmt.Sprintf("(%s -> %s::text)", b.column, b.arg(attr))
+}
+
+// jsonValue - параметр со значением, приведенным к jsonb
+func (b *sqlBuilder) jsonValue(v any) string {
+	data, _ := json.Marshal(v)
+	return b.arg(string(data)) + "::jsonb"
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) eval(attrs map[string]any) bool {
+	return n.left.eval(attrs) && n.right.eval(attrs)
+}
+
+func (n *andNode) sql(b *sqlBuilder) string {
+	return fmt.Sprintf("(%s AND %s)", n.left.sql(b), n.right.sql(b))
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) eval(attrs map[string]any) bool {
+	return n.left.eval(attrs) || n.right.eval(attrs)
+}
+
+func (n *orNode) sql(b *sqlBuilder) string {
+	return fmt.Sprintf("(%s OR %s)", n.left.sql(b), n.right.sql(b))
+}
+
+type notNode struct {
+	inner node
+}
+
+func (n *notNode) eval(attrs map[string]any) bool {
+	return !n.inner.eval(attrs)
+}
+
+func (n *notNode) sql(b *sqlBuilder) string {
+	return fmt.Sprintf("(NOT %s)", n.inner.sql(b))
+}
+
+// cmpNode - сравнение атрибута attr со значением val
+type cmpNode struct {
+	attr string
+	op   tokenKind
+	val  any
+}
+
+func (n *cmpNode) eval(attrs map[string]any) bool {
+	attrVal, ok := attrs[n.attr]
+
+	switch n.op {
+	case tokEq:
+		return ok && valuesEqual(attrVal, n.val)
+	case tokNeq:
+		return !ok || !valuesEqual(attrVal, n.val)
+	}
+
+	if !ok {
+		return false
+	}
+
+	var cmp int
+
+	switch v := n.val.(type) {
+	case float64:
+		num, isNum := toNumber(attrVal)
+		if !isNum {
+			return false
+		}
+
+		switch {
+		case num < v:
+			cmp = -1
+		case num > v:
+			cmp = 1
+		}
+	case string:
+		str, isStr := attrVal.(string)
+		if !isStr {
+			return false
+		}
+
+		cmp = strings.Compare(str, v)
+	default:
+		return false
+	}
+
+	switch n.op {
+	case tokLt:
+		return cmp < 0
+	case tokLte:
+		return cmp <= 0
+	case tokGt:
+		return cmp > 0
+	case tokGte:
+		return cmp >= 0
+	}
+
+	return false
+}
+
+func (n *cmpNode) sql(b *sqlBuilder) string {
+	switch n.op {
+	case tokEq:
+		return fmt.Sprintf("COALESCE(%s = %s, false)", b.field(n.attr), b.jsonValue(n.val))
+	case tokNeq:
+		return fmt.Sprintf("(%s IS DISTINCT FROM %s)", b.field(n.attr), b.jsonValue(n.val))
+	}
+
+	sqlOps := map[tokenKind]string{tokLt: "<", tokLte: "<=", tokGt: ">", tokGte: ">="}
+
+	// Сравниваем только значения того же типа: числа как numeric, строки побайтово, как strings.Compare
+	switch v := n.val.(type) {
+	case float64:
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'number' THEN (%s ->> %s::text)::numeric %s %s::numeric ELSE false END)",
+			b.field(n.attr), b.column, b.arg(n.attr), sqlOps[n.op], b.arg(v))
+	default:
+		return fmt.Sprintf("(CASE WHEN jsonb_typeof(%s) = 'string' THEN (%s ->> %s::text) COLLATE \"C\" %s %s::text ELSE false END)",
+			b.field(n.attr), b.column, b.arg(n.attr), sqlOps[n.op], b.arg(v))
+	}
+}
+
+// inNode - проверка вхождения атрибута attr в список vals
+type inNode struct {
+	attr   string
+	vals   []any
+	negate bool
+}
+
+func (n *inNode) eval(attrs map[string]any) bool {
+	attrVal, ok := attrs[n.attr]
+	found := false
+
+	if ok {
+		for _, v := range n.vals {
+			if valuesEqual(attrVal, v) {
+				found = true
+				break
+			}
+		}
+	}
+
+	return found != n.negate
+}
+
+func (n *inNode) sql(b *sqlBuilder) string {
+	field := b.field(n.attr)
+	placeholders := make([]string, 0, len(n.vals))
+
+	for _, v := range n.vals {
+		placeholders = append(placeholders, b.jsonValue(v))
+	}
+
+	cond := fmt.Sprintf("COALESCE(%s IN (%s), false)", field, strings.Join(placeholders, ", "))
+	if n.negate {
+		return fmt.Sprintf("(NOT %s)", cond)
+	}
+
+	return cond
+}
+
+// valuesEqual - равенство значения атрибута и значения из выражения с учетом типа, как у jsonb
+func valuesEqual(attrVal, val any) bool {
+	switch v := val.(type) {
+	case float64:
+		num, ok := toNumber(attrVal)
+		return ok && num == v
+	case string:
+		str, ok := attrVal.(string)
+		return ok && str == v
+	case bool:
+		bl, ok := attrVal.(bool)
+		return ok && bl == v
+	}
+
+	return false
+}
+
+// toNumber - привести числовое значение атрибута к float64
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
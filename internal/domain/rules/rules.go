@@ -0,0 +1,329 @@
+/*
+Package rules - язык условий на атрибуты пользователя, по которым отбираются пользователи при распространении сегмента.
+
+Пример выражения: country in ("RU", "KZ") && platform == "ios" && signup_date >= "2024-01-01".
+Поддерживаются операторы ==, !=, <, <=, >, >=, in, not in, логические &&, ||, ! и скобки.
+Значения - строки в двойных кавычках, числа и true/false. Отсутствующий у пользователя атрибут
+не равен никакому значению, поэтому для него истинны только != и not in.
+Значения известных атрибутов (страна, платформа, тариф, дата регистрации) - только строки, и они приводятся
+к тому же виду, в котором атрибуты хранятся у пользователей: platform == "iOS" означает platform == "ios".
+
+Одно и то же выражение можно проверить в памяти (Match) и перевести в условие SQL (SQL) - результаты совпадают
+*/
+package rules
+
+import (
+	"fmt"
+	"main/internal/domain/models"
+	"strconv"
+)
+
+const (
+	// maxRuleLength - максимальная длина выражения фильтра в символах
+	maxRuleLength = 4096
+	// maxRuleDepth - максимальная вложенность выражения фильтра
+	maxRuleDepth = 64
+)
+
+// ParseError - ошибка разбора выражения фильтра с позицией (с единицы) в исходной строке
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Rule - разобранное выражение фильтра. Пустой (nil) Rule пропускает всех пользователей
+type Rule struct {
+	src  string
+	root node
+}
+
+// Parse - разобрать и проверить выражение фильтра
+func Parse(src string) (*Rule, error) {
+	if len(src) > maxRuleLength {
+		return nil, &ParseError{Pos: 0, Msg: fmt.Sprintf("expression is longer than %d characters", maxRuleLength)}
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	if p.peek().kind == tokEOF {
+		return nil, &ParseError{Pos: 0, Msg: "expression is empty"}
+	}
+
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+
+	return &Rule{src: src, root: root}, nil
+}
+
+// String - исходный текст выражения
+func (r *Rule) String() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.src
+}
+
+// Match - проверить, подходит ли пользователь с атрибутами attrs под условие
+func (r *Rule) Match(attrs map[string]any) bool {
+	if r == nil {
+		return true
+	}
+
+	return r.root.eval(attrs)
+}
+
+/*
+	SQL - перевести условие в выражение SQL над jsonb-столбцом column.
+
+Значения передаются параметрами: они дописываются к args, а номера плейсхолдеров продолжают нумерацию args
+*/
+func (r *Rule) SQL(column string, args []any) (string, []any) {
+	if r == nil {
+		return "TRUE", args
+	}
+
+	b := &sqlBuilder{column: column, args: args}
+	cond := r.root.sql(b)
+
+	return cond, b.args
+}
+
+// parser - разбор выражения рекурсивным спуском
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("expected %s, got %q", what, tok.text)}
+	}
+
+	return tok, nil
+}
+
+func (p *parser) checkDepth(depth int) error {
+	if depth > maxRuleDepth {
+		return &ParseError{Pos: p.peek().pos, Msg: "expression is nested too deeply"}
+	}
+
+	return nil
+}
+
+// parseOr - or := and ("||" and)*
+func (p *parser) parseOr(depth int) (node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokOr {
+		p.next()
+
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd - and := unary ("&&" unary)*
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokAnd {
+		p.next()
+
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseUnary - unary := "!" unary | "(" or ")" | comparison
+func (p *parser) parseUnary(depth int) (node, error) {
+	if err := p.checkDepth(depth); err != nil {
+		return nil, err
+	}
+
+	switch p.peek().kind {
+	case tokBang:
+		p.next()
+
+		inner, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &notNode{inner: inner}, nil
+	case tokLParen:
+		p.next()
+
+		inner, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+
+		return inner, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+// parseComparison - comparison := ident op value | ident ["not"] "in" "(" value ("," value)* ")"
+func (p *parser) parseComparison() (node, error) {
+	attr, err := p.expect(tokIdent, "attribute name")
+	if err != nil {
+		return nil, err
+	}
+
+	opTok := p.next()
+
+	switch opTok.kind {
+	case tokEq, tokNeq, tokLt, tokLte, tokGt, tokGte:
+		valTok := p.peek()
+
+		val, err := p.parseValue(attr.text)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, isBool := val.(bool); isBool && opTok.kind != tokEq && opTok.kind != tokNeq {
+			return nil, &ParseError{Pos: valTok.pos, Msg: fmt.Sprintf("operator %q is not supported for booleans", opTok.text)}
+		}
+
+		return &cmpNode{attr: attr.text, op: opTok.kind, val: val}, nil
+	case tokNot:
+		if _, err := p.expect(tokIn, `"in"`); err != nil {
+			return nil, err
+		}
+
+		vals, err := p.parseList(attr.text)
+		if err != nil {
+			return nil, err
+		}
+
+		return &inNode{attr: attr.text, vals: vals, negate: true}, nil
+	case tokIn:
+		vals, err := p.parseList(attr.text)
+		if err != nil {
+			return nil, err
+		}
+
+		return &inNode{attr: attr.text, vals: vals}, nil
+	default:
+		return nil, &ParseError{Pos: opTok.pos, Msg: fmt.Sprintf("expected comparison operator after %q, got %q", attr.text, opTok.text)}
+	}
+}
+
+// parseList - "(" value ("," value)* ")" - значения атрибута attr
+func (p *parser) parseList(attr string) ([]any, error) {
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return nil, err
+	}
+
+	vals := make([]any, 0)
+
+	for {
+		val, err := p.parseValue(attr)
+		if err != nil {
+			return nil, err
+		}
+
+		vals = append(vals, val)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return vals, nil
+		}
+
+		if tok.kind != tokComma {
+			return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf(`expected "," or ")", got %q`, tok.text)}
+		}
+	}
+}
+
+/*
+	parseValue - value := string | number | true | false - значение атрибута attr.
+
+Значения известных атрибутов приводятся к виду, в котором атрибуты хранятся у пользователей
+*/
+func (p *parser) parseValue(attr string) (any, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokNumber, tokTrue, tokFalse:
+		if models.IsKnownAttribute(attr) {
+			return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("attribute %s is compared with strings only, got %q", attr, tok.text)}
+		}
+	}
+
+	switch tok.kind {
+	case tokString:
+		val, err := models.NormalizeAttribute(attr, tok.text)
+		if err != nil {
+			return nil, &ParseError{Pos: tok.pos, Msg: err.Error()}
+		}
+
+		return val, nil
+	case tokNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+
+		return num, nil
+	case tokTrue:
+		return true, nil
+	case tokFalse:
+		return false, nil
+	default:
+		return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("expected value, got %q", tok.text)}
+	}
+}
@@ -0,0 +1,257 @@
+package rules
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "", "position 1: expression is empty"},
+		{"only spaces", "   ", "position 1: expression is empty"},
+		{"too long", strings.Repeat("a", maxRuleLength+1), "position 1: expression is longer than 4096 characters"},
+		{"single ampersand", `a == 1 & b == 2`, `position 8: unexpected "&", did you mean "&&"`},
+		{"single pipe", `a == 1 | b == 2`, `position 8: unexpected "|", did you mean "||"`},
+		{"single equals", `country = "RU"`, `position 9: unexpected "=", did you mean "=="`},
+		{"unterminated string", `country == "RU`, "position 12: unterminated string"},
+		{"unsupported escape", `country == "R\nU"`, `position 14: only \" and \\ escapes are supported`},
+		{"invalid number", `age > 1.2.3`, `position 7: invalid number "1.2.3"`},
+		{"unexpected character", `age > @`, `position 7: unexpected character "@"`},
+		{"missing value", `country ==`, `position 11: expected value, got "end of expression"`},
+		{"missing operator", `country "RU"`, `position 9: expected comparison operator after "country", got "RU"`},
+		{"missing attribute", `== "RU"`, `position 1: expected attribute name, got "=="`},
+		{"trailing token", `age > 1 age`, `position 9: unexpected "age"`},
+		{"unclosed paren", `(age > 1`, `position 9: expected ")", got "end of expression"`},
+		{"not without in", `country not "RU"`, `position 13: expected "in", got "RU"`},
+		{"list without paren", `country in "RU"`, `position 12: expected "(", got "RU"`},
+		{"list without comma", `country in ("RU" "KZ")`, `position 18: expected "," or ")", got "KZ"`},
+		{"empty list", `country in ()`, `position 13: expected value, got ")"`},
+		{"ordered boolean", `premium < true`, `position 11: operator "<" is not supported for booleans`},
+		{"invalid country", `country == "RUS"`, `position 12: attribute country: "RUS" is not a two-letter country code`},
+		{"empty platform", `platform in ("ios", "")`, `position 21: attribute platform: value is empty`},
+		{"invalid signup date", `signup_date >= "01.01.2024"`, `position 16: attribute signup_date: "01.01.2024" is not a date in format YYYY-MM-DD`},
+		{"number for known attribute", `country == 1`, `position 12: attribute country is compared with strings only, got "1"`},
+		{"boolean for known attribute", `plan in ("pro", true)`, `position 17: attribute plan is compared with strings only, got "true"`},
+		{"unicode position", `страна = "RU"`, `position 8: unexpected "=", did you mean "=="`},
+		{
+			"too deep",
+			strings.Repeat("(", maxRuleDepth+1) + "a == 1" + strings.Repeat(")", maxRuleDepth+1),
+			"position 66: expression is nested too deeply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.src)
+			require.Error(t, err)
+			assert.Nil(t, rule)
+
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.want, err.Error())
+		})
+	}
+}
+
+func TestParseAcceptsMaxDepth(t *testing.T) {
+	src := strings.Repeat("(", maxRuleDepth) + "a == 1" + strings.Repeat(")", maxRuleDepth)
+
+	rule, err := Parse(src)
+	require.NoError(t, err)
+	assert.Equal(t, src, rule.String())
+}
+
+// matchAttrs - атрибуты пользователя в том виде, в каком они приходят из JSON
+var matchAttrs = map[string]any{
+	"country":     "RU",
+	"platform":    "ios",
+	"signup_date": "2024-03-01",
+	"age":         float64(30),
+	"premium":     true,
+	"age_str":     "30",
+	"plan":        nil,
+	"city":        "Moscow",
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		// ==
+		{`country == "RU"`, true},
+		{`country == "KZ"`, false},
+		{`age == 30`, true},
+		{`age == 30.0`, true},
+		{`age == "30"`, false},
+		{`age_str == 30`, false},
+		{`premium == true`, true},
+		{`premium == false`, false},
+		{`missing == "RU"`, false},
+		{`plan == "free"`, false},
+		// !=
+		{`country != "KZ"`, true},
+		{`country != "RU"`, false},
+		{`age != "30"`, true},
+		{`missing != "RU"`, true},
+		{`plan != "free"`, true},
+		// числовые сравнения
+		{`age < 31`, true},
+		{`age < 30`, false},
+		{`age <= 30`, true},
+		{`age > 29.5`, true},
+		{`age > 30`, false},
+		{`age >= 30`, true},
+		{`age >= -1`, true},
+		{`age_str > 1`, false},
+		{`city > 1`, false},
+		{`missing < 100`, false},
+		// строковые сравнения
+		{`signup_date >= "2024-01-01"`, true},
+		{`signup_date < "2024-01-01"`, false},
+		{`signup_date <= "2024-03-01"`, true},
+		{`signup_date > "2024-03-01"`, false},
+		{`city > "M"`, true},
+		{`city < "a"`, true},
+		{`age > "1"`, false},
+		{`missing >= ""`, false},
+		// in / not in
+		{`country in ("RU", "KZ")`, true},
+		{`country in ("KZ", "BY")`, false},
+		{`age in (1, 30)`, true},
+		{`age in ("30")`, false},
+		{`premium in (true)`, true},
+		{`missing in ("RU")`, false},
+		{`country not in ("KZ", "BY")`, true},
+		{`country not in ("RU")`, false},
+		{`missing not in ("RU")`, true},
+		// логика
+		{`country == "RU" && platform == "ios"`, true},
+		{`country == "RU" && platform == "android"`, false},
+		{`country == "KZ" || platform == "ios"`, true},
+		{`country == "KZ" || platform == "android"`, false},
+		{`!(country == "KZ")`, true},
+		{`!(missing == "RU")`, true},
+		{`!!(country == "RU")`, true},
+		{`country == "KZ" || country == "RU" && age > 100`, false},
+		{`(country == "KZ" || country == "RU") && age > 18`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			rule, err := Parse(tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule.Match(matchAttrs))
+		})
+	}
+}
+
+// Значения известных атрибутов хранятся приведенными, поэтому так же приводятся и значения в условии
+func TestNormalizedLiterals(t *testing.T) {
+	tests := []struct {
+		src      string
+		wantArgs []any
+	}{
+		{`platform == "iOS"`, []any{"platform", `"ios"`}},
+		{`country in ("ru", " kz ")`, []any{"country", `"RU"`, `"KZ"`}},
+		{`plan not in ("PRO")`, []any{"plan", `"pro"`}},
+		{`signup_date >= "2024-03-01T01:00:00+03:00"`, []any{"signup_date", "signup_date", "2024-02-29"}},
+		{`city == "Moscow"`, []any{"city", `"Moscow"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			rule, err := Parse(tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.src, rule.String())
+
+			_, args := rule.SQL("u.attributes", nil)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+
+	rule, err := Parse(`platform == "iOS" && country in ("ru") && plan == "Pro"`)
+	require.NoError(t, err)
+	assert.True(t, rule.Match(map[string]any{"platform": "ios", "country": "RU", "plan": "pro"}))
+}
+
+func TestMatchNumberTypes(t *testing.T) {
+	rule, err := Parse(`age >= 30 && age in (30, 40)`)
+	require.NoError(t, err)
+
+	for _, age := range []any{30, int32(30), int64(30), float32(30), float64(30), json.Number("30")} {
+		assert.True(t, rule.Match(map[string]any{"age": age}), "%T", age)
+	}
+
+	assert.False(t, rule.Match(map[string]any{"age": json.Number("abc")}))
+}
+
+func TestNilRule(t *testing.T) {
+	var rule *Rule
+
+	assert.True(t, rule.Match(nil))
+	assert.Equal(t, "", rule.String())
+
+	cond, args := rule.SQL("u.attributes", []any{1})
+	assert.Equal(t, "TRUE", cond)
+	assert.Equal(t, []any{1}, args)
+}
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		src      string
+		wantCond string
+		wantArgs []any
+	}{
+		{
+			`country == "RU"`,
+			`COALESCE((u.attributes -> $2::text) = $3::jsonb, false)`,
+			[]any{"country", `"RU"`},
+		},
+		{
+			`age != 30`,
+			`((u.attributes -> $2::text) IS DISTINCT FROM $3::jsonb)`,
+			[]any{"age", `30`},
+		},
+		{
+			`age >= 18`,
+			`(CASE WHEN jsonb_typeof((u.attributes -> $2::text)) = 'number' THEN (u.attributes ->> $3::text)::numeric >= $4::numeric ELSE false END)`,
+			[]any{"age", "age", float64(18)},
+		},
+		{
+			`signup_date < "2024-01-01"`,
+			`(CASE WHEN jsonb_typeof((u.attributes -> $2::text)) = 'string' THEN (u.attributes ->> $3::text) COLLATE "C" < $4::text ELSE false END)`,
+			[]any{"signup_date", "signup_date", "2024-01-01"},
+		},
+		{
+			`city not in ("Moscow", true)`,
+			`(NOT COALESCE((u.attributes -> $2::text) IN ($3::jsonb, $4::jsonb), false))`,
+			[]any{"city", `"Moscow"`, `true`},
+		},
+		{
+			`!(premium == true) || a == 1 && b == 2`,
+			`((NOT COALESCE((u.attributes -> $2::text) = $3::jsonb, false)) OR (COALESCE((u.attributes -> $4::text) = $5::jsonb, false) AND COALESCE((u.attributes -> $6::text) = $7::jsonb, false)))`,
+			[]any{"premium", `true`, "a", `1`, "b", `2`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			rule, err := Parse(tt.src)
+			require.NoError(t, err)
+
+			// Нумерация плейсхолдеров продолжает уже переданные параметры
+			cond, args := rule.SQL("u.attributes", []any{"segment"})
+			assert.Equal(t, tt.wantCond, cond)
+			assert.Equal(t, append([]any{"segment"}, tt.wantArgs...), args)
+		})
+	}
+}
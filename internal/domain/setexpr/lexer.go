@@ -0,0 +1,112 @@
+package setexpr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenKind - тип лексемы выражения над сегментами
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokUnion
+	tokIntersect
+	tokExcept
+	tokLParen
+	tokRParen
+)
+
+// token - лексема выражения с позицией начала в исходной строке
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// keywords - операции над множествами, регистр не важен
+var keywords = map[string]tokenKind{
+	"union":     tokUnion,
+	"intersect": tokIntersect,
+	"except":    tokExcept,
+}
+
+// isIdentRune - символ, допустимый в id сегмента без кавычек
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || r == ':' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lex - разбить выражение на лексемы
+func lex(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(src)
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == '"':
+			start := i
+			var sb strings.Builder
+			i++
+
+			for {
+				if i >= len(runes) {
+					return nil, &ParseError{Pos: start, Msg: "unterminated quoted segment id"}
+				}
+
+				if runes[i] == '"' {
+					i++
+					break
+				}
+
+				if runes[i] == '\\' {
+					if i+1 >= len(runes) || (runes[i+1] != '"' && runes[i+1] != '\\') {
+						return nil, &ParseError{Pos: i, Msg: `only \" and \\ escapes are supported`}
+					}
+					i++
+				}
+
+				sb.WriteRune(runes[i])
+				i++
+			}
+
+			if sb.Len() == 0 {
+				return nil, &ParseError{Pos: start, Msg: "empty segment id"}
+			}
+
+			tokens = append(tokens, token{kind: tokIdent, text: sb.String(), pos: start})
+		case isIdentRune(r):
+			start := i
+
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+
+			text := string(runes[start:i])
+			kind, ok := keywords[strings.ToLower(text)]
+			if !ok {
+				kind = tokIdent
+			}
+
+			tokens = append(tokens, token{kind: kind, text: text, pos: start})
+		default:
+			return nil, &ParseError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", string(r))}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(runes)})
+
+	return tokens, nil
+}
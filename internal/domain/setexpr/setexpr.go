@@ -0,0 +1,287 @@
+/*
+Package setexpr - выражения над множествами участников сегментов для производных сегментов.
+
+Пример: (BETA_TESTERS union EARLY_ADOPTERS) except PAID_PLAN.
+Операции union, intersect и except (регистр не важен), intersect связывает сильнее union и except,
+как в SQL. Id сегмента записывается как есть, если состоит из букв, цифр и символов _-.:, иначе - в двойных кавычках.
+
+Одно и то же выражение можно вычислить в памяти (Match) и перевести в запрос SQL (SQL) - результаты совпадают
+*/
+package setexpr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxExprLength - максимальная длина выражения в символах
+	maxExprLength = 4096
+	// maxExprDepth - максимальная вложенность выражения
+	maxExprDepth = 64
+)
+
+// ParseError - ошибка разбора выражения с позицией (с единицы) в исходной строке
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos+1, e.Msg)
+}
+
+// node - узел дерева выражения: ссылка на сегмент или операция над двумя подвыражениями
+type node struct {
+	op          tokenKind // tokIdent для ссылки на сегмент
+	segmentId   string
+	left, right *node
+}
+
+// Expr - разобранное выражение над сегментами
+type Expr struct {
+	root *node
+}
+
+// Parse - разобрать и проверить выражение
+func Parse(src string) (*Expr, error) {
+	if len(src) > maxExprLength {
+		return nil, &ParseError{Pos: 0, Msg: fmt.Sprintf("expression is longer than %d characters", maxExprLength)}
+	}
+
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	if p.peek().kind == tokEOF {
+		return nil, &ParseError{Pos: 0, Msg: "expression is empty"}
+	}
+
+	root, err := p.parseUnion(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+
+	return &Expr{root: root}, nil
+}
+
+// Segments - id сегментов, на которые ссылается выражение, без повторов и по возрастанию
+func (e *Expr) Segments() []string {
+	seen := make(map[string]bool)
+	e.root.walk(func(n *node) {
+		if n.op == tokIdent {
+			seen[n.segmentId] = true
+		}
+	})
+
+	res := make([]string, 0, len(seen))
+	for id := range seen {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// Rename - копия выражения, в которой ссылки на сегмент oldId заменены на newId
+func (e *Expr) Rename(oldId, newId string) *Expr {
+	return &Expr{root: e.root.rename(oldId, newId)}
+}
+
+// String - выражение в каноническом виде: операции в нижнем регистре, вложенные операции в скобках
+func (e *Expr) String() string {
+	return e.root.String()
+}
+
+// Match - проверить, входит ли пользователь в результат. memberOf сообщает, состоит ли пользователь в сегменте
+func (e *Expr) Match(memberOf func(segmentId string) bool) bool {
+	return e.root.eval(memberOf)
+}
+
+/*
+	SQL - перевести выражение в запрос, выбирающий user_id участников результата из users_segments.
+
+Id сегментов передаются параметрами: они дописываются к args, а номера плейсхолдеров продолжают нумерацию args
+*/
+func (e *Expr) SQL(args []any) (string, []any) {
+	return e.root.sql(args)
+}
+
+func (n *node) walk(fn func(*node)) {
+	fn(n)
+
+	if n.op != tokIdent {
+		n.left.walk(fn)
+		n.right.walk(fn)
+	}
+}
+
+func (n *node) rename(oldId, newId string) *node {
+	if n.op == tokIdent {
+		if n.segmentId == oldId {
+			return &node{op: tokIdent, segmentId: newId}
+		}
+
+		return &node{op: tokIdent, segmentId: n.segmentId}
+	}
+
+	return &node{op: n.op, left: n.left.rename(oldId, newId), right: n.right.rename(oldId, newId)}
+}
+
+func (n *node) eval(memberOf func(string) bool) bool {
+	switch n.op {
+	case tokUnion:
+		return n.left.eval(memberOf) || n.right.eval(memberOf)
+	case tokIntersect:
+		return n.left.eval(memberOf) && n.right.eval(memberOf)
+	case tokExcept:
+		return n.left.eval(memberOf) && !n.right.eval(memberOf)
+	default:
+		return memberOf(n.segmentId)
+	}
+}
+
+// sqlOps - операции над множествами в SQL
+var sqlOps = map[tokenKind]string{tokUnion: "UNION", tokIntersect: "INTERSECT", tokExcept: "EXCEPT"}
+
+func (n *node) sql(args []any) (string, []any) {
+	if n.op == tokIdent {
+		args = append(args, n.segmentId)
+		return fmt.Sprintf("(SELECT user_id FROM users_segments WHERE segment_id = $%d)", len(args)), args
+	}
+
+	left, args := n.left.sql(args)
+	right, args := n.right.sql(args)
+
+	return fmt.Sprintf("(%s %s %s)", left, sqlOps[n.op], right), args
+}
+
+func (n *node) String() string {
+	if n.op == tokIdent {
+		return quoteId(n.segmentId)
+	}
+
+	return fmt.Sprintf("%s %s %s", n.left.operand(), strings.ToLower(sqlOps[n.op]), n.right.operand())
+}
+
+// operand - запись подвыражения как операнда: вложенные операции берутся в скобки
+func (n *node) operand() string {
+	if n.op == tokIdent {
+		return n.String()
+	}
+
+	return "(" + n.String() + ")"
+}
+
+// quoteId - записать id сегмента так, чтобы Parse прочитал его обратно
+func quoteId(id string) string {
+	plain := id != ""
+	for _, r := range id {
+		if !isIdentRune(r) {
+			plain = false
+			break
+		}
+	}
+
+	if _, isKeyword := keywords[strings.ToLower(id)]; plain && !isKeyword {
+		return id
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(id) + `"`
+}
+
+// parser - разбор выражения рекурсивным спуском
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+// parseUnion - union := intersect (("union" | "except") intersect)*
+func (p *parser) parseUnion(depth int) (*node, error) {
+	left, err := p.parseIntersect(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokUnion || p.peek().kind == tokExcept {
+		op := p.next().kind
+
+		right, err := p.parseIntersect(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &node{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseIntersect - intersect := operand ("intersect" operand)*
+func (p *parser) parseIntersect(depth int) (*node, error) {
+	left, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokIntersect {
+		p.next()
+
+		right, err := p.parseOperand(depth)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &node{op: tokIntersect, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseOperand - operand := segment_id | "(" union ")"
+func (p *parser) parseOperand(depth int) (*node, error) {
+	if depth > maxExprDepth {
+		return nil, &ParseError{Pos: p.peek().pos, Msg: "expression is nested too deeply"}
+	}
+
+	tok := p.next()
+
+	switch tok.kind {
+	case tokIdent:
+		return &node{op: tokIdent, segmentId: tok.text}, nil
+	case tokLParen:
+		inner, err := p.parseUnion(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &ParseError{Pos: closing.pos, Msg: fmt.Sprintf(`expected ")", got %q`, closing.text)}
+		}
+
+		return inner, nil
+	default:
+		return nil, &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("expected segment id, got %q", tok.text)}
+	}
+}
@@ -0,0 +1,149 @@
+package setexpr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", " ", "position 1: expression is empty"},
+		{"too long", strings.Repeat("A", maxExprLength+1), "position 1: expression is longer than 4096 characters"},
+		{"missing operand", "A union", `position 8: expected segment id, got "end of expression"`},
+		{"leading operator", "except A", `position 1: expected segment id, got "except"`},
+		{"missing operator", "A B", `position 3: unexpected "B"`},
+		{"unclosed paren", "(A union B", `position 11: expected ")", got "end of expression"`},
+		{"extra paren", "A union B)", `position 10: unexpected ")"`},
+		{"unexpected character", "A + B", `position 3: unexpected character "+"`},
+		{"unterminated quote", `A union "B`, "position 9: unterminated quoted segment id"},
+		{"empty quoted id", `A union ""`, "position 9: empty segment id"},
+		{"bad escape", `"A\n"`, `position 3: only \" and \\ escapes are supported`},
+		{
+			"too deep",
+			strings.Repeat("(", maxExprDepth+1) + "A" + strings.Repeat(")", maxExprDepth+1),
+			"position 66: expression is nested too deeply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.src)
+			require.Error(t, err)
+			assert.Nil(t, expr)
+
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.want, err.Error())
+		})
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"A union B intersect C", "A union (B intersect C)"},
+		{"A intersect B union C", "(A intersect B) union C"},
+		{"A except B intersect C", "A except (B intersect C)"},
+		{"A except B union C", "(A except B) union C"},
+		{"A union B except C", "(A union B) except C"},
+		{"A except (B union C)", "A except (B union C)"},
+		{"A INTERSECT b Union C", "(A intersect b) union C"},
+		{"((A))", "A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := Parse(tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestQuotingRoundTrip(t *testing.T) {
+	ids := []string{
+		"PLAIN_ID",
+		"with-dash.and:colon",
+		"Сегмент_1",
+		"with space",
+		`with "quotes"`,
+		`back\slash`,
+		"union",
+		"Except",
+		"(paren)",
+	}
+
+	for _, id := range ids {
+		t.Run(id, func(t *testing.T) {
+			expr, err := Parse("A union OLD")
+			require.NoError(t, err)
+
+			renamed := expr.Rename("OLD", id)
+			assert.ElementsMatch(t, []string{"A", id}, renamed.Segments())
+
+			reparsed, err := Parse(renamed.String())
+			require.NoError(t, err, renamed.String())
+			assert.Equal(t, renamed.String(), reparsed.String())
+			assert.ElementsMatch(t, []string{"A", id}, reparsed.Segments())
+
+			// Исходное выражение не меняется
+			assert.Equal(t, "A union OLD", expr.String())
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	expr, err := Parse("(C union A) except (B intersect A)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, expr.Segments())
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want func(a, b, c bool) bool
+	}{
+		{"A union B", func(a, b, c bool) bool { return a || b }},
+		{"A intersect B", func(a, b, c bool) bool { return a && b }},
+		{"A except B", func(a, b, c bool) bool { return a && !b }},
+		{"A union B intersect C", func(a, b, c bool) bool { return a || (b && c) }},
+		{"A except B intersect C", func(a, b, c bool) bool { return a && !(b && c) }},
+		{"A except B except C", func(a, b, c bool) bool { return a && !b && !c }},
+		{"A except (B except C)", func(a, b, c bool) bool { return a && !(b && !c) }},
+		{"(A union B) except C", func(a, b, c bool) bool { return (a || b) && !c }},
+		{"A intersect A", func(a, b, c bool) bool { return a }},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.src)
+		require.NoError(t, err)
+
+		for mask := 0; mask < 8; mask++ {
+			a, b, c := mask&1 != 0, mask&2 != 0, mask&4 != 0
+			member := map[string]bool{"A": a, "B": b, "C": c}
+
+			got := expr.Match(func(segmentId string) bool { return member[segmentId] })
+			assert.Equal(t, tt.want(a, b, c), got, "%s with A=%v B=%v C=%v", tt.src, a, b, c)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	expr, err := Parse(`A union B intersect "C D"`)
+	require.NoError(t, err)
+
+	query, args := expr.SQL([]any{"first"})
+	assert.Equal(t, "((SELECT user_id FROM users_segments WHERE segment_id = $2) UNION "+
+		"((SELECT user_id FROM users_segments WHERE segment_id = $3) INTERSECT "+
+		"(SELECT user_id FROM users_segments WHERE segment_id = $4)))", query)
+	assert.Equal(t, []any{"first", "A", "B", "C D"}, args)
+}
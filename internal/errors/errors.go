@@ -15,7 +15,16 @@ var (
 	ErrUserExists           = errors.New("user already exists")
 	ErrUserNotFound         = errors.New("user not found")
 	ErrShardUnavailable     = errors.New("shard unavailable")
-	ErrSegmentDistributed   = errors.New("segment distributed")
+	ErrSegmentArchived      = errors.New("segment archived")
+	ErrInvalidStatusChange  = errors.New("segment can not be moved back to draft")
+	ErrLayerAlreadyExists   = errors.New("layer already exists")
+	ErrLayerNotFound        = errors.New("layer not found")
+	ErrSourceNotFound       = errors.New("source segment not found")
+	ErrDerivedMemberCap     = errors.New("derived segment can not have a member limit")
+	ErrOverrideNotFound     = errors.New("override not found")
+	ErrVariantNotFound      = errors.New("variant not found")
+	ErrPayloadMismatch      = errors.New("payload does not match payload schema")
+	ErrInvalidSchedule      = errors.New("expires_at must be after starts_at")
 )
 
 // errorToCode - Отображение публичных ошибок в коды ответа Grpc
@@ -25,7 +34,16 @@ var errorToCode = map[error]codes.Code{
 	ErrUserExists:           codes.AlreadyExists,
 	ErrUserNotFound:         codes.NotFound,
 	ErrSegmentNotFound:      codes.NotFound,
-	ErrSegmentDistributed:   codes.AlreadyExists,
+	ErrSegmentArchived:      codes.FailedPrecondition,
+	ErrInvalidStatusChange:  codes.FailedPrecondition,
+	ErrLayerAlreadyExists:   codes.AlreadyExists,
+	ErrLayerNotFound:        codes.NotFound,
+	ErrSourceNotFound:       codes.NotFound,
+	ErrDerivedMemberCap:     codes.FailedPrecondition,
+	ErrOverrideNotFound:     codes.NotFound,
+	ErrVariantNotFound:      codes.NotFound,
+	ErrPayloadMismatch:      codes.InvalidArgument,
+	ErrInvalidSchedule:      codes.InvalidArgument,
 }
 
 // isPublic - функция, проверяющая, является ли ошибка публичной
@@ -1,13 +1,27 @@
 package segmentationrpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/jsonschema"
 	"main/internal/domain/models"
+	"main/internal/domain/rules"
+	"main/internal/domain/setexpr"
 	segv1 "main/protos/gen/go/segmentation"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // ServerApi - Занимается валидацией входных данных запросов и отправляет ответы пользователю
@@ -19,21 +33,259 @@ type ServerApi struct {
 type Segmentation interface {
 	CreateSegment(segment models.Segment) (string, error)
 	DeleteSegment(id string) (string, error)
-	UpdateSegment(id string, newSegment models.Segment) (string, error)
+	UpdateSegment(id string, newSegment models.Segment, clearStartsAt, clearExpiresAt bool) (string, error)
 	GetUserSegments(id int) ([]models.Segment, error)
 	GetSegmentInfo(id string) (models.SegmentInfo, error)
-	DistributeSegment(id string, usersPercentage int) (string, error)
+	DistributeSegment(id string, target models.DistributionTarget, rule *rules.Rule, autoEnroll bool) (models.DistributionResult, error)
+	PreviewDistribution(id string, target models.DistributionTarget, rule *rules.Rule, sampleSize int) (models.DistributionPreview, error)
+	AddUsersToSegment(id string, userIds []int) (models.MembershipChange, error)
+	RemoveUsersFromSegment(id string, userIds []int) (models.MembershipChange, error)
+	GetUserSegmentHistory(id int, from, to time.Time) ([]models.HistoryEntry, error)
+	SetSegmentStatus(id string, status string) (string, error)
+	CreateLayer(layer models.Layer) (string, error)
+	ListSegments(filter models.SegmentsFilter) (models.SegmentsPage, error)
+	ListSegmentMembers(ctx context.Context, id string, after int, batchSize int, send func([]models.SegmentMember) error) error
+	BatchGetUserSegments(userIds []int) (map[int][]models.Segment, []int, error)
+	CreateDerivedSegment(segment models.Segment, expr *setexpr.Expr, mode string) (int64, error)
+	InHoldout(id int) bool
+	GetHoldoutInfo() (models.HoldoutInfo, error)
+	GetSegmentStatsHistory(id string, from, to time.Time) (models.StatsHistory, error)
+	GetSegmentOverlap(ids []string) (models.SegmentOverlap, error)
+	SetUserOverride(override models.Override) error
+	ClearUserOverride(userId int, segmentId string) error
+	EvaluateUser(userId int, attrs map[string]any) ([]models.Evaluation, error)
+	CheckMembership(id int, segmentIds []string) (map[string]bool, error)
 }
 
+// statusToModel - отображение статусов сегмента из grpc в статусы модели
+var statusToModel = map[segv1.SegmentStatus]string{
+	segv1.SegmentStatus_SEGMENT_STATUS_DRAFT:    models.SegmentStatusDraft,
+	segv1.SegmentStatus_SEGMENT_STATUS_ACTIVE:   models.SegmentStatusActive,
+	segv1.SegmentStatus_SEGMENT_STATUS_PAUSED:   models.SegmentStatusPaused,
+	segv1.SegmentStatus_SEGMENT_STATUS_ARCHIVED: models.SegmentStatusArchived,
+}
+
+// statusFromModel - обратное отображение статусов сегмента
+var statusFromModel = map[string]segv1.SegmentStatus{
+	models.SegmentStatusDraft:    segv1.SegmentStatus_SEGMENT_STATUS_DRAFT,
+	models.SegmentStatusActive:   segv1.SegmentStatus_SEGMENT_STATUS_ACTIVE,
+	models.SegmentStatusPaused:   segv1.SegmentStatus_SEGMENT_STATUS_PAUSED,
+	models.SegmentStatusArchived: segv1.SegmentStatus_SEGMENT_STATUS_ARCHIVED,
+}
+
+// overrideActionToModel - отображение действий переопределения из grpc в действия модели
+var overrideActionToModel = map[segv1.OverrideAction]string{
+	segv1.OverrideAction_OVERRIDE_ACTION_INCLUDE: models.OverrideInclude,
+	segv1.OverrideAction_OVERRIDE_ACTION_EXCLUDE: models.OverrideExclude,
+}
+
+// evaluationReasonFromModel - отображение причин решения при оценке пользователя в grpc
+var evaluationReasonFromModel = map[string]segv1.EvaluationReason{
+	models.EvaluationReasonTargeted:       segv1.EvaluationReason_EVALUATION_REASON_TARGETED,
+	models.EvaluationReasonOverride:       segv1.EvaluationReason_EVALUATION_REASON_OVERRIDE,
+	models.EvaluationReasonInactive:       segv1.EvaluationReason_EVALUATION_REASON_INACTIVE,
+	models.EvaluationReasonHoldout:        segv1.EvaluationReason_EVALUATION_REASON_HOLDOUT,
+	models.EvaluationReasonNotDistributed: segv1.EvaluationReason_EVALUATION_REASON_NOT_DISTRIBUTED,
+	models.EvaluationReasonCountTarget:    segv1.EvaluationReason_EVALUATION_REASON_COUNT_TARGET,
+	models.EvaluationReasonBucket:         segv1.EvaluationReason_EVALUATION_REASON_BUCKET,
+	models.EvaluationReasonRule:           segv1.EvaluationReason_EVALUATION_REASON_RULE,
+	models.EvaluationReasonLayer:          segv1.EvaluationReason_EVALUATION_REASON_LAYER,
+	models.EvaluationReasonDerived:        segv1.EvaluationReason_EVALUATION_REASON_DERIVED,
+	models.EvaluationReasonNoAutoEnroll:   segv1.EvaluationReason_EVALUATION_REASON_NO_AUTO_ENROLL,
+	models.EvaluationReasonFull:           segv1.EvaluationReason_EVALUATION_REASON_FULL,
+}
+
+// derivedModeToModel - отображение режимов производного сегмента из grpc в режимы модели. По умолчанию - снимок
+var derivedModeToModel = map[segv1.DerivedMode]string{
+	segv1.DerivedMode_DERIVED_MODE_UNSPECIFIED: models.DerivedModeSnapshot,
+	segv1.DerivedMode_DERIVED_MODE_SNAPSHOT:    models.DerivedModeSnapshot,
+	segv1.DerivedMode_DERIVED_MODE_LIVE:        models.DerivedModeLive,
+}
+
+// derivedModeFromModel - отображение режимов производного сегмента модели в grpc
+var derivedModeFromModel = map[string]segv1.DerivedMode{
+	models.DerivedModeSnapshot: segv1.DerivedMode_DERIVED_MODE_SNAPSHOT,
+	models.DerivedModeLive:     segv1.DerivedMode_DERIVED_MODE_LIVE,
+}
+
+// maxUsersPerRequest - максимальное число пользователей в одном запросе на ручное изменение сегмента
+const maxUsersPerRequest = 10000
+
+const (
+	// defaultPageSize - размер страницы ListSegments, если он не задан
+	defaultPageSize = 50
+	// maxPageSize - максимальный размер страницы ListSegments
+	maxPageSize = 500
+	// defaultMembersBatchSize - число участников в одном сообщении ListSegmentMembers, если оно не задано
+	defaultMembersBatchSize = 1000
+	// maxMembersBatchSize - максимальное число участников в одном сообщении ListSegmentMembers
+	maxMembersBatchSize = 10000
+	// defaultSampleSize - число примеров пользователей в ответе DistributeSegment с dry_run, если оно не задано
+	defaultSampleSize = 20
+	// maxSampleSize - максимальное число примеров пользователей в ответе DistributeSegment с dry_run
+	maxSampleSize = 1000
+	// maxOverlapSegments - максимальное число сегментов в одном запросе GetSegmentOverlap
+	maxOverlapSegments = 50
+	// maxCheckSegments - максимальное число сегментов в одном запросе CheckMembership
+	maxCheckSegments = 100
+	// maxPayloadSize - максимальный размер параметров сегмента, варианта или их схемы в байтах
+	maxPayloadSize = 64 * 1024
+)
+
 func Register(gRPC *grpc.Server, segmentation Segmentation) {
 	segv1.RegisterSegmentationServer(gRPC, &ServerApi{segServ: segmentation})
 }
 
 func (s *ServerApi) CreateSegment(ctx context.Context, req *segv1.CreateSegmentRequest) (*segv1.CreateSegmentResponse, error) {
-	id, err := s.segServ.CreateSegment(models.Segment{Id: req.Id, Description: req.Description})
+	startsAt, expiresAt, err := parseSchedule(req.GetStartsAt(), req.GetExpiresAt())
+	if err != nil {
+		return nil, err
+	}
+
+	var segStatus string
+	if req.GetStatus() != segv1.SegmentStatus_SEGMENT_STATUS_UNSPECIFIED {
+		var ok bool
+		if segStatus, ok = statusToModel[req.GetStatus()]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid segment status")
+		}
+	}
+
+	variants, err := parseVariants(req.GetVariants())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.MaxMembers != nil && (req.GetMaxMembers() <= 0 || req.GetMaxMembers() > math.MaxInt32) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max members")
+	}
+
+	payload, err := parsePayload(req.GetPayload(), "payload")
+	if err != nil {
+		return nil, err
+	}
+
+	schemaSrc, err := parsePayloadSchema(parsePayload(req.GetPayloadSchema(), "payload schema"))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.segServ.CreateSegment(models.Segment{
+		Id:            req.Id,
+		Description:   req.Description,
+		StartsAt:      startsAt,
+		ExpiresAt:     expiresAt,
+		Salt:          req.GetSalt(),
+		Status:        segStatus,
+		LayerId:       req.GetLayerId(),
+		Variants:      variants,
+		MaxMembers:    req.MaxMembers,
+		Payload:       payload,
+		PayloadSchema: schemaSrc,
+	})
 	return &segv1.CreateSegmentResponse{Id: id}, err
 }
 
+/*
+	CreateDerivedSegment - создать сегмент из выражения над существующими сегментами,
+	например (BETA_TESTERS union EARLY_ADOPTERS) except PAID_PLAN.
+
+По умолчанию состав вычисляется один раз (SNAPSHOT). В режиме LIVE он пересчитывается при изменении исходных сегментов
+*/
+func (s *ServerApi) CreateDerivedSegment(ctx context.Context, req *segv1.CreateDerivedSegmentRequest) (*segv1.CreateDerivedSegmentResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "segment id is required")
+	}
+
+	expr, err := setexpr.Parse(req.GetExpression())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid expression: %s", err.Error())
+	}
+
+	mode, ok := derivedModeToModel[req.GetMode()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid derived segment mode")
+	}
+
+	startsAt, expiresAt, err := parseSchedule(req.GetStartsAt(), req.GetExpiresAt())
+	if err != nil {
+		return nil, err
+	}
+
+	usersNum, err := s.segServ.CreateDerivedSegment(models.Segment{
+		Id:          req.GetId(),
+		Description: req.GetDescription(),
+		StartsAt:    startsAt,
+		ExpiresAt:   expiresAt,
+	}, expr, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segv1.CreateDerivedSegmentResponse{Id: req.GetId(), UsersNum: usersNum}, nil
+}
+
+/*
+	GetHoldoutInfo - доля глобальной контрольной группы и ее размер на каждом шарде.
+
+Если задан user_id, дополнительно сообщает, входит ли пользователь в группу
+*/
+func (s *ServerApi) GetHoldoutInfo(ctx context.Context, req *segv1.GetHoldoutInfoRequest) (*segv1.GetHoldoutInfoResponse, error) {
+	var userId *int
+	if req.UserId != nil {
+		id, err := parseUserId(req.GetUserId())
+		if err != nil {
+			return nil, err
+		}
+
+		userId = &id
+	}
+
+	info, err := s.segServ.GetHoldoutInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.GetHoldoutInfoResponse{
+		Percentage: info.Percentage,
+		Shards:     make([]*segv1.ShardHoldout, 0, len(info.Shards)),
+	}
+
+	for _, sh := range info.Shards {
+		resp.Shards = append(resp.Shards, &segv1.ShardHoldout{
+			Shard:        int32(sh.ShardId),
+			UsersNum:     sh.UsersNum,
+			HoldoutUsers: sh.HoldoutUsers,
+		})
+		resp.UsersNum += sh.UsersNum
+		resp.HoldoutUsers += sh.HoldoutUsers
+	}
+
+	if userId != nil {
+		inHoldout := s.segServ.InHoldout(*userId)
+		resp.UserInHoldout = &inHoldout
+	}
+
+	return resp, nil
+}
+
+/*
+	CreateLayer - создать слой взаимоисключающих сегментов.
+
+Сегменты привязываются к слою при создании; пользователь состоит не более чем в одном сегменте слоя
+*/
+func (s *ServerApi) CreateLayer(ctx context.Context, req *segv1.CreateLayerRequest) (*segv1.CreateLayerResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "layer id is required")
+	}
+
+	id, err := s.segServ.CreateLayer(models.Layer{Id: req.GetId(), Description: req.GetDescription()})
+	if err != nil {
+		return nil, err
+	}
+
+	return &segv1.CreateLayerResponse{Id: id}, nil
+}
+
 func (s *ServerApi) DeleteSegment(ctx context.Context, req *segv1.DeleteSegmentRequest) (*segv1.DeleteSegmentResponse, error) {
 	id, err := s.segServ.DeleteSegment(req.Id)
 	if err != nil {
@@ -58,7 +310,41 @@ func (s *ServerApi) UpdateSegment(ctx context.Context, req *segv1.UpdateSegmentR
 		newDescription = *req.NewDescription
 	}
 
-	id, err := s.segServ.UpdateSegment(req.Id, models.Segment{Id: newId, Description: newDescription})
+	startsAt, expiresAt, err := parseSchedule(req.GetStartsAt(), req.GetExpiresAt())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetClearStartsAt() && startsAt != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "starts_at can not be set and cleared at once")
+	}
+
+	if req.GetClearExpiresAt() && expiresAt != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "expires_at can not be set and cleared at once")
+	}
+
+	if req.NewMaxMembers != nil && (req.GetNewMaxMembers() < 0 || req.GetNewMaxMembers() > math.MaxInt32) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max members")
+	}
+
+	payload, err := parsePayloadUpdate(req.NewPayload, "payload")
+	if err != nil {
+		return nil, err
+	}
+
+	schemaSrc, err := parsePayloadSchema(parsePayloadUpdate(req.NewPayloadSchema, "payload schema"))
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := parseVariantPayloads(req.GetNewVariantPayloads())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.segServ.UpdateSegment(req.Id, models.Segment{Id: newId, Description: newDescription, StartsAt: startsAt, ExpiresAt: expiresAt,
+		MaxMembers: req.NewMaxMembers, Payload: payload, PayloadSchema: schemaSrc, Variants: variants},
+		req.GetClearStartsAt(), req.GetClearExpiresAt())
 	if err != nil {
 		return nil, err
 	}
@@ -66,21 +352,203 @@ func (s *ServerApi) UpdateSegment(ctx context.Context, req *segv1.UpdateSegmentR
 	return &segv1.UpdateSegmentResponse{Id: id}, nil
 }
 
+/*
+	SetSegmentStatus - смена статуса сегмента.
+
+Приостановленные и архивные сегменты не выдаются пользователям, но сохраняют участников. Восстановление - перевод в ACTIVE
+*/
+func (s *ServerApi) SetSegmentStatus(ctx context.Context, req *segv1.SetSegmentStatusRequest) (*segv1.SetSegmentStatusResponse, error) {
+	segStatus, ok := statusToModel[req.GetStatus()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid segment status")
+	}
+
+	id, err := s.segServ.SetSegmentStatus(req.GetId(), segStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segv1.SetSegmentStatusResponse{Id: id, Status: req.GetStatus()}, nil
+}
+
+/*
+	SetUserOverride - принудительно включить пользователя в сегмент или исключить из него, например для тестирования.
+
+Переопределение действует поверх обычного членства и распространения до expires_at, если он задан
+*/
+func (s *ServerApi) SetUserOverride(ctx context.Context, req *segv1.SetUserOverrideRequest) (*segv1.SetUserOverrideResponse, error) {
+	userId, err := parseUserId(req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetSegmentId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "segment id is required")
+	}
+
+	action, ok := overrideActionToModel[req.GetAction()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid override action")
+	}
+
+	_, expiresAt, err := parseSchedule(nil, req.GetExpiresAt())
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, status.Errorf(codes.InvalidArgument, "expires_at must be in the future")
+	}
+
+	err = s.segServ.SetUserOverride(models.Override{
+		UserId:    userId,
+		SegmentId: req.GetSegmentId(),
+		Action:    action,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &segv1.SetUserOverrideResponse{
+		UserId:    req.GetUserId(),
+		SegmentId: req.GetSegmentId(),
+		Action:    req.GetAction(),
+		ExpiresAt: req.GetExpiresAt(),
+	}, nil
+}
+
+// ClearUserOverride - удалить переопределение, после чего членство пользователя в сегменте снова определяется распространением
+func (s *ServerApi) ClearUserOverride(ctx context.Context, req *segv1.ClearUserOverrideRequest) (*segv1.ClearUserOverrideResponse, error) {
+	userId, err := parseUserId(req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetSegmentId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "segment id is required")
+	}
+
+	if err := s.segServ.ClearUserOverride(userId, req.GetSegmentId()); err != nil {
+		return nil, err
+	}
+
+	return &segv1.ClearUserOverrideResponse{UserId: req.GetUserId(), SegmentId: req.GetSegmentId()}, nil
+}
+
 func (s *ServerApi) GetUserSegments(ctx context.Context, req *segv1.GetUserSegmentsRequest) (*segv1.GetUserSegmentsResponse, error) {
 	segs, err := s.segServ.GetUserSegments(int(req.Id))
 	if err != nil {
 		return nil, err
 	}
 
-	retCategs := make([]*segv1.CategoryInfo, 0)
+	return &segv1.GetUserSegmentsResponse{Categories: toCategories(segs)}, nil
+}
 
-	for _, seg := range segs {
-		newCatInf := &segv1.CategoryInfo{}
-		newCatInf.Id = seg.Id
-		retCategs = append(retCategs, newCatInf)
+/*
+	BatchGetUserSegments - сегменты нескольких пользователей за один запрос.
+
+Пользователи возвращаются в порядке запроса, несуществующие - в not_found_user_ids
+*/
+func (s *ServerApi) BatchGetUserSegments(ctx context.Context, req *segv1.BatchGetUserSegmentsRequest) (*segv1.BatchGetUserSegmentsResponse, error) {
+	userIds, err := parseUserIds(req.GetUserIds())
+	if err != nil {
+		return nil, err
+	}
+
+	segs, notFound, err := s.segServ.BatchGetUserSegments(userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.BatchGetUserSegmentsResponse{
+		Users:           make([]*segv1.UserSegments, 0, len(segs)),
+		NotFoundUserIds: models.UserIdsToInt64(notFound),
+	}
+
+	for _, id := range userIds {
+		if userSegs, ok := segs[id]; ok {
+			resp.Users = append(resp.Users, &segv1.UserSegments{UserId: int64(id), Categories: toCategories(userSegs)})
+		}
+	}
+
+	return resp, nil
+}
+
+/*
+	CheckMembership - состоит ли пользователь в каждом из заданных сегментов.
+
+Быстрее GetUserSegments, когда нужны только несколько сегментов: правила те же, включая переопределения
+*/
+func (s *ServerApi) CheckMembership(ctx context.Context, req *segv1.CheckMembershipRequest) (*segv1.CheckMembershipResponse, error) {
+	userId, err := parseUserId(req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := req.GetSegmentIds()
+
+	if len(ids) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "segment ids are required")
+	}
+
+	if len(ids) > maxCheckSegments {
+		return nil, status.Errorf(codes.InvalidArgument, "too many segments, max %d", maxCheckSegments)
+	}
+
+	for _, id := range ids {
+		if id == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "segment ids must be non-empty")
+		}
+	}
+
+	memberships, err := s.segServ.CheckMembership(userId, ids)
+	if err != nil {
+		return nil, err
 	}
 
-	return &segv1.GetUserSegmentsResponse{Categories: retCategs}, nil
+	return &segv1.CheckMembershipResponse{UserId: req.GetUserId(), Memberships: memberships}, nil
+}
+
+/*
+	EvaluateUser - какие сегменты получил бы пользователь с заданными атрибутами, без его создания.
+
+Для каждого сегмента возвращается решение и его причина
+*/
+func (s *ServerApi) EvaluateUser(ctx context.Context, req *segv1.EvaluateUserRequest) (*segv1.EvaluateUserResponse, error) {
+	userId, err := parseUserId(req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Атрибуты приводятся к тому же виду, в каком их сохраняет создание пользователя
+	attrs, err := models.NormalizeAttributes(req.GetAttributes().AsMap())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid attributes: %v", err)
+	}
+
+	evaluations, err := s.segServ.EvaluateUser(userId, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.EvaluateUserResponse{
+		UserId:    req.GetUserId(),
+		InHoldout: s.segServ.InHoldout(userId),
+		Decisions: make([]*segv1.SegmentDecision, 0, len(evaluations)),
+	}
+
+	for _, ev := range evaluations {
+		resp.Decisions = append(resp.Decisions, &segv1.SegmentDecision{
+			SegmentId: ev.SegmentId,
+			Member:    ev.Member,
+			Variant:   ev.Variant,
+			Reason:    evaluationReasonFromModel[ev.Reason],
+			Detail:    ev.Detail,
+		})
+	}
+
+	return resp, nil
 }
 
 func (s *ServerApi) GetSegmentInfo(ctx context.Context, req *segv1.GetSegmentInfoRequest) (*segv1.GetSegmentInfoResponse, error) {
@@ -89,23 +557,742 @@ func (s *ServerApi) GetSegmentInfo(ctx context.Context, req *segv1.GetSegmentInf
 		return nil, err
 	}
 
-	return &segv1.GetSegmentInfoResponse{Id: segInf.Id, Description: segInf.Description, UsersNum: segInf.UsersNum}, nil
+	resp := &segv1.GetSegmentInfoResponse{
+		Id:                segInf.Id,
+		Description:       segInf.Description,
+		UsersNum:          segInf.UsersNum,
+		Salt:              segInf.Salt,
+		UsersPercentage:   float64(segInf.TargetBuckets) * 100 / bucketing.BucketsNum,
+		Filter:            segInf.Rule,
+		AutoEnroll:        segInf.AutoEnroll,
+		Status:            statusFromModel[segInf.Status],
+		LayerId:           segInf.LayerId,
+		LayerFreeUsers:    segInf.LayerFreeUsers,
+		DerivedExpression: segInf.DerivedExpr,
+		DerivedMode:       derivedModeFromModel[segInf.DerivedMode],
+		TargetUsers:       segInf.TargetCount,
+		MaxMembers:        segInf.MaxMembers,
+		Payload:           string(segInf.Payload),
+		PayloadSchema:     string(segInf.PayloadSchema),
+	}
+
+	if segInf.MaxMembers != nil {
+		remaining := max(*segInf.MaxMembers-segInf.UsersNum, 0)
+		resp.RemainingCapacity = &remaining
+	}
+
+	for _, sh := range segInf.Shards {
+		resp.Shards = append(resp.Shards, &segv1.ShardUsers{Shard: int32(sh.ShardId), UsersNum: sh.UsersNum})
+	}
+
+	resp.Partial = len(segInf.FailedShards) > 0
+	resp.FailedShards = toInt32s(segInf.FailedShards)
+
+	for _, vi := range segInf.Variants {
+		resp.Variants = append(resp.Variants, &segv1.VariantInfo{
+			Name:     vi.Name,
+			Weight:   int32(vi.Weight),
+			UsersNum: vi.UsersNum,
+			Payload:  string(vi.Payload),
+		})
+	}
+
+	if segInf.LayerTotalUsers > 0 {
+		resp.LayerFreePercentage = float64(segInf.LayerFreeUsers) * 100 / float64(segInf.LayerTotalUsers)
+	}
+
+	if segInf.StartsAt != nil {
+		resp.StartsAt = timestamppb.New(*segInf.StartsAt)
+	}
+
+	if segInf.ExpiresAt != nil {
+		resp.ExpiresAt = timestamppb.New(*segInf.ExpiresAt)
+	}
+
+	return resp, nil
+}
+
+/*
+	ListSegments - каталог сегментов по возрастанию id с фильтрами по префиксу id и подстроке описания.
+
+Для следующей страницы в page_token передается next_page_token предыдущего ответа. Пустой next_page_token - страница последняя
+*/
+func (s *ServerApi) ListSegments(ctx context.Context, req *segv1.ListSegmentsRequest) (*segv1.ListSegmentsResponse, error) {
+	pageSize := int(req.GetPageSize())
+	switch {
+	case pageSize < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "page size must not be negative")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	after, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.segServ.ListSegments(models.SegmentsFilter{
+		IdPrefix:            req.GetIdPrefix(),
+		DescriptionContains: req.GetDescriptionContains(),
+		After:               after,
+		Limit:               pageSize,
+		WithCounts:          req.GetWithCounts(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.ListSegmentsResponse{Segments: make([]*segv1.SegmentListItem, 0, len(page.Segments))}
+
+	for _, si := range page.Segments {
+		item := &segv1.SegmentListItem{
+			Id:          si.Id,
+			Description: si.Description,
+			Status:      statusFromModel[si.Status],
+			LayerId:     si.LayerId,
+		}
+
+		if req.GetWithCounts() {
+			usersNum := si.UsersNum
+			item.UsersNum = &usersNum
+		}
+
+		if si.StartsAt != nil {
+			item.StartsAt = timestamppb.New(*si.StartsAt)
+		}
+
+		if si.ExpiresAt != nil {
+			item.ExpiresAt = timestamppb.New(*si.ExpiresAt)
+		}
+
+		resp.Segments = append(resp.Segments, item)
+	}
+
+	if page.Next != "" {
+		resp.NextPageToken = encodePageToken(page.Next)
+	}
+
+	return resp, nil
+}
+
+/*
+	ListSegmentMembers - поток участников сегмента по возрастанию user_id со всех шардов.
+
+Каждое сообщение содержит cursor - последний выданный user_id. Чтобы продолжить прерванную выгрузку,
+его передают в after_user_id
+*/
+func (s *ServerApi) ListSegmentMembers(req *segv1.ListSegmentMembersRequest, stream segv1.Segmentation_ListSegmentMembersServer) error {
+	if req.GetId() == "" {
+		return status.Errorf(codes.InvalidArgument, "segment id is required")
+	}
+
+	after := -1
+	if req.AfterUserId != nil {
+		if req.GetAfterUserId() < 0 || req.GetAfterUserId() > math.MaxInt32 {
+			return status.Errorf(codes.InvalidArgument, "invalid after_user_id %d", req.GetAfterUserId())
+		}
+		after = int(req.GetAfterUserId())
+	}
+
+	batchSize := int(req.GetBatchSize())
+	switch {
+	case batchSize < 0:
+		return status.Errorf(codes.InvalidArgument, "batch size must not be negative")
+	case batchSize == 0:
+		batchSize = defaultMembersBatchSize
+	case batchSize > maxMembersBatchSize:
+		batchSize = maxMembersBatchSize
+	}
+
+	return s.segServ.ListSegmentMembers(stream.Context(), req.GetId(), after, batchSize, func(members []models.SegmentMember) error {
+		chunk := &segv1.ListSegmentMembersResponse{
+			Members: make([]*segv1.SegmentMember, 0, len(members)),
+			Cursor:  int64(members[len(members)-1].UserId),
+		}
+
+		for _, m := range members {
+			chunk.Members = append(chunk.Members, &segv1.SegmentMember{UserId: int64(m.UserId), Variant: m.Variant})
+		}
+
+		return stream.Send(chunk)
+	})
 }
 
+/*
+DistributeSegment - распространение сегмента на долю пользователей (users_percentage, можно дробную, до сотых процента)
+или на точное число пользователей (users_count), которое делится между шардами пропорционально числу подходящих на них пользователей.
+С dry_run выборка считается на всех шардах без записи, в ответе есть примеры пользователей
+*/
 func (s *ServerApi) DistributeSegment(ctx context.Context, req *segv1.DistributeSegmentRequest) (*segv1.DistributeSegmentResponse, error) {
-	i, err := strconv.ParseInt(req.GetUsersPercentage(), 10, 64)
+	target, err := parseDistributionTarget(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rule *rules.Rule
+	if strings.TrimSpace(req.GetFilter()) != "" {
+		rule, err = rules.Parse(req.GetFilter())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %s", err.Error())
+		}
+	}
+
+	if req.GetDryRun() {
+		return s.previewDistribution(req, target, rule)
+	}
+
+	res, err := s.segServ.DistributeSegment(req.GetId(), target, rule, req.GetAutoEnroll())
+	if err != nil {
+		return nil, err
+	}
+
+	return toDistributeResponse(res), nil
+}
+
+// previewDistribution - ответ DistributeSegment при dry_run: изменения без записи и примеры пользователей
+func (s *ServerApi) previewDistribution(req *segv1.DistributeSegmentRequest, target models.DistributionTarget, rule *rules.Rule) (*segv1.DistributeSegmentResponse, error) {
+	sampleSize := int(req.GetSampleSize())
+	if sampleSize < 0 || sampleSize > maxSampleSize {
+		return nil, status.Errorf(codes.InvalidArgument, "sample size must be between 0 and %d", maxSampleSize)
+	}
+
+	if sampleSize == 0 {
+		sampleSize = defaultSampleSize
+	}
+
+	res, err := s.segServ.PreviewDistribution(req.GetId(), target, rule, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := toDistributeResponse(res.DistributionResult)
+	resp.DryRun = true
+	resp.SampleUserIds = make([]int64, 0, len(res.SampleUserIds))
+
+	for _, userId := range res.SampleUserIds {
+		resp.SampleUserIds = append(resp.SampleUserIds, int64(userId))
+	}
+
+	return resp, nil
+}
+
+// toDistributeResponse - перевод результата распространения в ответ DistributeSegment
+func toDistributeResponse(res models.DistributionResult) *segv1.DistributeSegmentResponse {
+	shards := make([]*segv1.ShardDistribution, 0, len(res.Shards))
+
+	for _, sh := range res.Shards {
+		shards = append(shards, &segv1.ShardDistribution{
+			Shard:     int32(sh.ShardId),
+			Added:     sh.Added,
+			Removed:   sh.Removed,
+			Requested: sh.Requested,
+			Assigned:  sh.Assigned,
+			Rejected:  sh.Rejected,
+		})
+	}
+
+	return &segv1.DistributeSegmentResponse{
+		Id:       res.Id,
+		Shards:   shards,
+		Added:    res.Added(),
+		Removed:  res.Removed(),
+		Assigned: res.Assigned(),
+		Rejected: res.Rejected(),
+	}
+}
+
+// parseDistributionTarget - проверка цели распространения: задан ровно один из процента и числа пользователей
+func parseDistributionTarget(req *segv1.DistributeSegmentRequest) (models.DistributionTarget, error) {
+	if req.UsersCount != nil {
+		if req.GetUsersPercentage() != "" {
+			return models.DistributionTarget{}, status.Errorf(codes.InvalidArgument, "users percentage and users count are mutually exclusive")
+		}
+
+		if req.GetUsersCount() < 0 || req.GetUsersCount() > math.MaxInt32 {
+			return models.DistributionTarget{}, status.Errorf(codes.InvalidArgument, "invalid users count")
+		}
+
+		if req.GetAutoEnroll() {
+			return models.DistributionTarget{}, status.Errorf(codes.InvalidArgument, "auto enroll is not supported for distribution by users count")
+		}
+
+		return models.DistributionTarget{Count: req.GetUsersCount(), ByCount: true}, nil
+	}
+
+	percentage, err := strconv.ParseFloat(strings.TrimSpace(req.GetUsersPercentage()), 64)
+	if err != nil || math.IsNaN(percentage) || percentage < 0 || percentage > 100 {
+		return models.DistributionTarget{}, status.Errorf(codes.InvalidArgument, "invalid users percentage")
+	}
+
+	return models.DistributionTarget{Percentage: percentage}, nil
+}
+
+func (s *ServerApi) AddUsersToSegment(ctx context.Context, req *segv1.AddUsersToSegmentRequest) (*segv1.AddUsersToSegmentResponse, error) {
+	userIds, err := parseUserIds(req.GetUserIds())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid users percentage")
+		return nil, err
 	}
 
-	if i <= 0 || i > 100 {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid users percentage")
+	res, err := s.segServ.AddUsersToSegment(req.GetId(), userIds)
+	if err != nil {
+		return nil, err
 	}
 
-	id, err := s.segServ.DistributeSegment(req.GetId(), int(i))
+	return &segv1.AddUsersToSegmentResponse{
+		Id:               req.GetId(),
+		AddedIds:         models.UserIdsToInt64(res.Changed),
+		UnknownUserIds:   models.UserIdsToInt64(res.Unknown),
+		AlreadyMemberIds: models.UserIdsToInt64(res.Unchanged),
+		LayerConflictIds: models.UserIdsToInt64(res.Conflicts),
+		RejectedIds:      models.UserIdsToInt64(res.Rejected),
+	}, nil
+}
+
+func (s *ServerApi) RemoveUsersFromSegment(ctx context.Context, req *segv1.RemoveUsersFromSegmentRequest) (*segv1.RemoveUsersFromSegmentResponse, error) {
+	userIds, err := parseUserIds(req.GetUserIds())
 	if err != nil {
 		return nil, err
 	}
 
-	return &segv1.DistributeSegmentResponse{Id: id}, nil
+	res, err := s.segServ.RemoveUsersFromSegment(req.GetId(), userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segv1.RemoveUsersFromSegmentResponse{
+		Id:             req.GetId(),
+		RemovedIds:     models.UserIdsToInt64(res.Changed),
+		UnknownUserIds: models.UserIdsToInt64(res.Unknown),
+		NotMemberIds:   models.UserIdsToInt64(res.Unchanged),
+	}, nil
+}
+
+// GetSegmentStatsHistory - рост числа участников сегмента по ежедневным снимкам
+func (s *ServerApi) GetSegmentStatsHistory(ctx context.Context, req *segv1.GetSegmentStatsHistoryRequest) (*segv1.GetSegmentStatsHistoryResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "segment id is required")
+	}
+
+	from, to, err := parsePeriod(req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.segServ.GetSegmentStatsHistory(req.GetId(), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.GetSegmentStatsHistoryResponse{
+		Id:           history.Id,
+		Points:       make([]*segv1.StatsPoint, 0, len(history.Points)),
+		Partial:      len(history.FailedShards) > 0,
+		FailedShards: toInt32s(history.FailedShards),
+	}
+
+	for _, p := range history.Points {
+		resp.Points = append(resp.Points, &segv1.StatsPoint{
+			Day:      timestamppb.New(p.Day),
+			UsersNum: p.UsersNum,
+			Shards:   int32(p.Shards),
+		})
+	}
+
+	return resp, nil
+}
+
+/*
+	GetSegmentOverlap - матрица попарных пересечений сегментов: сколько пользователей состоит в обоих.
+
+Порядок строк и столбцов совпадает с порядком segment_ids в запросе
+*/
+func (s *ServerApi) GetSegmentOverlap(ctx context.Context, req *segv1.GetSegmentOverlapRequest) (*segv1.GetSegmentOverlapResponse, error) {
+	ids := req.GetSegmentIds()
+
+	if len(ids) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "segment ids are required")
+	}
+
+	if len(ids) > maxOverlapSegments {
+		return nil, status.Errorf(codes.InvalidArgument, "too many segments, max %d", maxOverlapSegments)
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "segment ids must be non-empty and distinct")
+		}
+		seen[id] = true
+	}
+
+	overlap, err := s.segServ.GetSegmentOverlap(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.GetSegmentOverlapResponse{
+		SegmentIds:   overlap.Ids,
+		Rows:         make([]*segv1.OverlapRow, 0, len(overlap.Ids)),
+		Partial:      len(overlap.FailedShards) > 0,
+		FailedShards: toInt32s(overlap.FailedShards),
+	}
+
+	for i := range overlap.Ids {
+		row := &segv1.OverlapRow{Counts: overlap.Counts[i]}
+
+		if req.GetWithJaccard() {
+			row.Jaccard = make([]float64, len(overlap.Ids))
+			for j := range overlap.Ids {
+				row.Jaccard[j] = overlap.Jaccard(i, j)
+			}
+		}
+
+		resp.Rows = append(resp.Rows, row)
+	}
+
+	return resp, nil
+}
+
+/*
+	GetUserSegmentHistory - история членства пользователя в сегментах.
+
+При as_csv история возвращается одной строкой в формате CSV вместо списка записей
+*/
+func (s *ServerApi) GetUserSegmentHistory(ctx context.Context, req *segv1.GetUserSegmentHistoryRequest) (*segv1.GetUserSegmentHistoryResponse, error) {
+	userId, err := parseUserId(req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, err := parsePeriod(req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.segServ.GetUserSegmentHistory(userId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &segv1.GetUserSegmentHistoryResponse{UserId: req.GetUserId()}
+
+	if req.GetAsCsv() {
+		resp.Csv, err = historyToCsv(entries)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "internal server error")
+		}
+
+		return resp, nil
+	}
+
+	resp.Entries = make([]*segv1.HistoryEntry, 0, len(entries))
+
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &segv1.HistoryEntry{
+			SegmentId:         e.SegmentId,
+			Action:            e.Action,
+			Reason:            e.Reason,
+			CreatedAt:         timestamppb.New(e.CreatedAt),
+			PreviousSegmentId: e.PreviousSegmentId,
+		})
+	}
+
+	return resp, nil
+}
+
+// historyToCsv - выгрузка истории членства в CSV с заголовком
+func historyToCsv(entries []models.HistoryEntry) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"user_id", "segment_id", "action", "reason", "created_at", "previous_segment_id"}); err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		record := []string{
+			strconv.Itoa(e.UserId),
+			e.SegmentId,
+			e.Action,
+			e.Reason,
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			e.PreviousSegmentId,
+		}
+
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// parseUserIds - проверка списка id пользователей из запроса. Повторяющиеся id схлопываются
+func parseUserIds(ids []int64) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "user ids are empty")
+	}
+
+	if len(ids) > maxUsersPerRequest {
+		return nil, status.Errorf(codes.InvalidArgument, "too many user ids, max is %d", maxUsersPerRequest)
+	}
+
+	seen := make(map[int64]bool, len(ids))
+	res := make([]int, 0, len(ids))
+
+	for _, id := range ids {
+		userId, err := parseUserId(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		res = append(res, userId)
+	}
+
+	return res, nil
+}
+
+// parseUserId - проверка id пользователя из запроса
+func parseUserId(id int64) (int, error) {
+	if id < 0 || id > math.MaxInt32 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid user id %d", id)
+	}
+
+	return int(id), nil
+}
+
+/*
+	toCategories - перевод сегментов пользователя в формат ответа.
+
+Сегменты уже отобраны сервисом, поэтому OverrideOnly здесь означает, что членство задано переопределением
+*/
+func toCategories(segs []models.Segment) []*segv1.CategoryInfo {
+	retCategs := make([]*segv1.CategoryInfo, 0, len(segs))
+
+	for _, seg := range segs {
+		retCategs = append(retCategs, &segv1.CategoryInfo{
+			Id:      seg.Id,
+			Variant: seg.Variant,
+			Forced:  seg.OverrideOnly,
+			Payload: string(seg.Payload),
+		})
+	}
+
+	return retCategs
+}
+
+// toInt32s - перевод номеров шардов в формат ответа
+func toInt32s(ids []int) []int32 {
+	res := make([]int32, len(ids))
+
+	for i, id := range ids {
+		res[i] = int32(id)
+	}
+
+	return res
+}
+
+/*
+	parseVariants - проверка вариантов эксперимента из запроса.
+
+Вариантов должно быть не меньше двух, имена - непустые и различные, веса - положительные,
+а их сумма не больше числа бакетов, иначе доли вариантов нельзя выдержать
+*/
+func parseVariants(variants []*segv1.Variant) ([]models.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+
+	if len(variants) < 2 {
+		return nil, status.Errorf(codes.InvalidArgument, "experiment must have at least two variants")
+	}
+
+	seen := make(map[string]bool, len(variants))
+	res := make([]models.Variant, 0, len(variants))
+	total := 0
+
+	for _, v := range variants {
+		if v.GetName() == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "variant name is required")
+		}
+
+		if seen[v.GetName()] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate variant %q", v.GetName())
+		}
+
+		if v.GetWeight() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "variant %q must have positive weight", v.GetName())
+		}
+
+		seen[v.GetName()] = true
+		total += int(v.GetWeight())
+		payload, err := parsePayload(v.GetPayload(), fmt.Sprintf("payload of variant %q", v.GetName()))
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, models.Variant{Name: v.GetName(), Weight: int(v.GetWeight()), Payload: payload})
+	}
+
+	if total > bucketing.BucketsNum {
+		return nil, status.Errorf(codes.InvalidArgument, "total variant weight must not exceed %d", bucketing.BucketsNum)
+	}
+
+	return res, nil
+}
+
+// parsePayload - проверка JSON-значения what. Пустая строка - значение не задано
+func parsePayload(src string, what string) (json.RawMessage, error) {
+	if src == "" {
+		return nil, nil
+	}
+
+	if len(src) > maxPayloadSize {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is larger than %d bytes", what, maxPayloadSize)
+	}
+
+	if !json.Valid([]byte(src)) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not valid JSON", what)
+	}
+
+	return json.RawMessage(src), nil
+}
+
+// parsePayloadUpdate - новое JSON-значение what при изменении сегмента: nil - не менять, JSON null - удалить
+func parsePayloadUpdate(src *string, what string) (json.RawMessage, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	if *src == "" {
+		return json.RawMessage("null"), nil
+	}
+
+	return parsePayload(*src, what)
+}
+
+// parseVariantPayloads - новые параметры вариантов по имени варианта, в порядке имен. Пустая строка удаляет параметры
+func parseVariantPayloads(payloads map[string]string) ([]models.Variant, error) {
+	names := make([]string, 0, len(payloads))
+	for name := range payloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]models.Variant, 0, len(names))
+	for _, name := range names {
+		src := payloads[name]
+
+		payload, err := parsePayloadUpdate(&src, fmt.Sprintf("payload of variant %q", name))
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, models.Variant{Name: name, Payload: payload})
+	}
+
+	return res, nil
+}
+
+/*
+	parsePayloadSchema - проверка, что схема параметров разбирается. JSON null (удаление схемы) пропускается.
+
+Соответствие параметров схеме проверяет хранилище в транзакции изменения сегмента
+*/
+func parsePayloadSchema(schemaSrc json.RawMessage, err error) (json.RawMessage, error) {
+	if err != nil || len(schemaSrc) == 0 || string(schemaSrc) == "null" {
+		return schemaSrc, err
+	}
+
+	if _, err := jsonschema.Compile(schemaSrc); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid payload schema: %s", err.Error())
+	}
+
+	return schemaSrc, nil
+}
+
+// encodePageToken - непрозрачный для клиента курсор страницы по id последнего сегмента
+func encodePageToken(lastId string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastId))
+}
+
+// decodePageToken - id последнего сегмента из курсора страницы. Пустой курсор - первая страница
+func decodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	lastId, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(lastId) == 0 {
+		return "", status.Errorf(codes.InvalidArgument, "invalid page token")
+	}
+
+	return string(lastId), nil
+}
+
+// parsePeriod - проверка и перевод в time.Time необязательных границ периода. Незаданная граница - нулевое время
+func parsePeriod(fromPb, toPb *timestamppb.Timestamp) (time.Time, time.Time, error) {
+	var from, to time.Time
+
+	if fromPb != nil {
+		if err := fromPb.CheckValid(); err != nil {
+			return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "invalid from")
+		}
+		from = fromPb.AsTime()
+	}
+
+	if toPb != nil {
+		if err := toPb.CheckValid(); err != nil {
+			return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "invalid to")
+		}
+		to = toPb.AsTime()
+	}
+
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "to must not be before from")
+	}
+
+	return from, to, nil
+}
+
+// parseSchedule - проверка и перевод в time.Time необязательных дат начала и окончания действия сегмента
+func parseSchedule(startsAtPb, expiresAtPb *timestamppb.Timestamp) (*time.Time, *time.Time, error) {
+	var startsAt, expiresAt *time.Time
+
+	if startsAtPb != nil {
+		if err := startsAtPb.CheckValid(); err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid starts_at")
+		}
+
+		t := startsAtPb.AsTime()
+		startsAt = &t
+	}
+
+	if expiresAtPb != nil {
+		if err := expiresAtPb.CheckValid(); err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid expires_at")
+		}
+
+		t := expiresAtPb.AsTime()
+		expiresAt = &t
+	}
+
+	if startsAt != nil && expiresAt != nil && !expiresAt.After(*startsAt) {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "expires_at must be after starts_at")
+	}
+
+	return startsAt, expiresAt, nil
 }
@@ -62,7 +62,13 @@ func (h *Handler) handleUserCreate(ctx context.Context, data []byte) error {
 		return fmt.Errorf("unmarshal user create message: %w", err)
 	}
 
-	_, err := h.userSvc.CreateUser(models.User{Id: event.ID})
+	attrs, err := models.NormalizeAttributes(event.Attributes)
+	if err != nil {
+		h.log.Error("invalid user attributes", slog.Int("id", event.ID), slog.String("error", err.Error()))
+		return fmt.Errorf("validate user attributes: %w", err)
+	}
+
+	_, err = h.userSvc.CreateUser(models.User{Id: event.ID, Attributes: attrs})
 	if err != nil {
 		h.log.Error("failed to create user", slog.String("error", err.Error()))
 		return fmt.Errorf("create user: %w", err)
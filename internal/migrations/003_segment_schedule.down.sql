@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS segments_expires_at_idx;
+
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_schedule_check;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE segments DROP COLUMN IF EXISTS starts_at;
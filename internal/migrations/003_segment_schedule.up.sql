@@ -0,0 +1,9 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- Сегмент, который истекает раньше, чем начинает действовать, никогда не включится, а sweeper удалит его вместе с участниками
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_schedule_check;
+ALTER TABLE segments ADD CONSTRAINT segments_schedule_check
+       CHECK (expires_at IS NULL OR starts_at IS NULL OR expires_at > starts_at);
+
+CREATE INDEX IF NOT EXISTS segments_expires_at_idx ON segments(expires_at);
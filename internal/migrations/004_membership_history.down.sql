@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_segments_history_trg ON users_segments;
+DROP FUNCTION IF EXISTS log_users_segments_change();
+DROP TABLE IF EXISTS users_segments_history;
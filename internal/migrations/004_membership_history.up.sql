@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS users_segments_history (
+       id BIGSERIAL PRIMARY KEY,
+       user_id INT NOT NULL,
+       segment_id TEXT NOT NULL,
+       action TEXT NOT NULL,
+       reason TEXT NOT NULL,
+       created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS users_segments_history_user_id_idx ON users_segments_history(user_id, created_at);
+
+-- Причину изменения задает приложение через set_config('segmentation.reason', ..., true) в той же транзакции
+CREATE OR REPLACE FUNCTION log_users_segments_change() RETURNS TRIGGER AS $$
+DECLARE
+       change_reason TEXT := COALESCE(NULLIF(current_setting('segmentation.reason', true), ''), 'unknown');
+BEGIN
+       IF TG_OP = 'INSERT' THEN
+              INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+              VALUES (NEW.user_id, NEW.segment_id, 'add', change_reason);
+              RETURN NEW;
+       END IF;
+
+       INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+       VALUES (OLD.user_id, OLD.segment_id, 'remove', change_reason);
+       RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_segments_history_trg ON users_segments;
+
+CREATE TRIGGER users_segments_history_trg
+       AFTER INSERT OR DELETE ON users_segments
+       FOR EACH ROW EXECUTE FUNCTION log_users_segments_change();
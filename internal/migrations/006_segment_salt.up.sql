@@ -0,0 +1,6 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS salt TEXT;
+
+-- Соль уже существующих сегментов должна совпадать на всех шардах, поэтому выводим ее из id
+UPDATE segments SET salt = md5(id) WHERE salt IS NULL;
+
+ALTER TABLE segments ALTER COLUMN salt SET NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE users_segments DROP COLUMN IF EXISTS source;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS rule;
+ALTER TABLE segments DROP COLUMN IF EXISTS target_buckets;
@@ -0,0 +1,11 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS target_buckets INT NOT NULL DEFAULT 0;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS rule TEXT NOT NULL DEFAULT '';
+
+-- Откуда пользователь попал в сегмент: при уменьшении процента удаляются только распространенные записи
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'distribution';
+
+UPDATE users_segments us SET source = 'manual'
+WHERE EXISTS (
+       SELECT 1 FROM users_segments_history h
+       WHERE h.user_id = us.user_id AND h.segment_id = us.segment_id AND h.action = 'add' AND h.reason = 'manual'
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS segments_auto_enroll_idx;
+
+ALTER TABLE segments DROP COLUMN IF EXISTS auto_enroll;
@@ -0,0 +1,3 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS auto_enroll BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS segments_auto_enroll_idx ON segments(auto_enroll) WHERE auto_enroll;
@@ -0,0 +1,5 @@
+ALTER TABLE segments DROP COLUMN IF EXISTS bucket_key;
+
+ALTER TABLE users_segments DROP CONSTRAINT IF EXISTS segment_id_fk;
+ALTER TABLE users_segments ADD CONSTRAINT segment_id_fk
+       FOREIGN KEY (segment_id) REFERENCES segments(id) ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+-- Переименование сегмента каскадно меняет segment_id в users_segments
+ALTER TABLE users_segments DROP CONSTRAINT IF EXISTS segment_id_fk;
+ALTER TABLE users_segments ADD CONSTRAINT segment_id_fk
+       FOREIGN KEY (segment_id) REFERENCES segments(id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- Исходный id сегмента, по которому считаются бакеты, чтобы переименование не меняло выборку пользователей
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS bucket_key TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_status_check;
+ALTER TABLE segments DROP COLUMN IF EXISTS status;
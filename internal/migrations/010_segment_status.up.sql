@@ -0,0 +1,5 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_status_check;
+ALTER TABLE segments ADD CONSTRAINT segments_status_check
+       CHECK (status IN ('draft', 'active', 'paused', 'archived'));
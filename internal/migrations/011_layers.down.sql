@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS users_segments_layer_trg ON users_segments;
+DROP FUNCTION IF EXISTS set_users_segments_layer();
+
+DROP INDEX IF EXISTS users_segments_user_layer_idx;
+ALTER TABLE users_segments DROP COLUMN IF EXISTS layer_id;
+
+DROP INDEX IF EXISTS segments_layer_id_idx;
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_layer_id_fk;
+ALTER TABLE segments DROP COLUMN IF EXISTS layer_id;
+
+DROP TABLE IF EXISTS layers;
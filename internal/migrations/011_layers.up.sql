@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS layers (
+       id TEXT PRIMARY KEY,
+       description TEXT
+);
+
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS layer_id TEXT;
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_layer_id_fk;
+ALTER TABLE segments ADD CONSTRAINT segments_layer_id_fk
+       FOREIGN KEY (layer_id) REFERENCES layers(id) ON UPDATE CASCADE;
+
+CREATE INDEX IF NOT EXISTS segments_layer_id_idx ON segments(layer_id);
+
+-- Слой сегмента копируется в users_segments, чтобы уникальный индекс не давал пользователю попасть в два сегмента слоя
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS layer_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_segments_user_layer_idx
+       ON users_segments(user_id, layer_id) WHERE layer_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION set_users_segments_layer() RETURNS TRIGGER AS $$
+BEGIN
+       NEW.layer_id := (SELECT layer_id FROM segments WHERE id = NEW.segment_id);
+       RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_segments_layer_trg ON users_segments;
+
+CREATE TRIGGER users_segments_layer_trg
+       BEFORE INSERT ON users_segments
+       FOR EACH ROW EXECUTE FUNCTION set_users_segments_layer();
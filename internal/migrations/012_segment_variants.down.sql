@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS users_segments_variant_trg ON users_segments;
+DROP FUNCTION IF EXISTS assign_users_segments_variant();
+
+ALTER TABLE users_segments DROP COLUMN IF EXISTS variant;
+
+DROP TABLE IF EXISTS segment_variants;
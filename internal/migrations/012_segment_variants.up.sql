@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS segment_variants (
+       segment_id TEXT NOT NULL,
+       name TEXT NOT NULL,
+       weight INT NOT NULL CHECK (weight > 0),
+       ord INT NOT NULL,
+       PRIMARY KEY (segment_id, name),
+       CONSTRAINT segment_variants_segment_id_fk FOREIGN KEY (segment_id)
+              REFERENCES segments(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+ALTER TABLE users_segments ADD COLUMN IF NOT EXISTS variant TEXT;
+
+-- Вариант выбирается по отдельному хэшу пользователя и накопленным весам вариантов в порядке ord.
+-- Формула совпадает с bucketing.Variant
+CREATE OR REPLACE FUNCTION assign_users_segments_variant() RETURNS TRIGGER AS $$
+DECLARE
+       seg_key TEXT;
+       seg_salt TEXT;
+       total BIGINT;
+       point BIGINT;
+BEGIN
+       IF NEW.variant IS NOT NULL THEN
+              RETURN NEW;
+       END IF;
+
+       SELECT SUM(weight) INTO total FROM segment_variants WHERE segment_id = NEW.segment_id;
+       IF total IS NULL THEN
+              RETURN NEW;
+       END IF;
+
+       SELECT COALESCE(bucket_key, id), salt INTO seg_key, seg_salt FROM segments WHERE id = NEW.segment_id;
+
+       point := (('x' || substr(md5(seg_key || ':variant:' || seg_salt || ':' || NEW.user_id::text), 1, 8))::bit(32)::bigint % 10000)
+                * total / 10000;
+
+       SELECT v.name INTO NEW.variant
+       FROM (
+              SELECT name, SUM(weight) OVER (ORDER BY ord) AS upper
+              FROM segment_variants WHERE segment_id = NEW.segment_id
+       ) v
+       WHERE point < v.upper
+       ORDER BY v.upper
+       LIMIT 1;
+
+       RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_segments_variant_trg ON users_segments;
+
+CREATE TRIGGER users_segments_variant_trg
+       BEFORE INSERT ON users_segments
+       FOR EACH ROW EXECUTE FUNCTION assign_users_segments_variant();
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS users_segments_segment_user_idx;
@@ -0,0 +1,2 @@
+-- Постраничное чтение участников сегмента по возрастанию user_id
+CREATE INDEX IF NOT EXISTS users_segments_segment_user_idx ON users_segments(segment_id, user_id);
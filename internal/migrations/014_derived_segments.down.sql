@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS segment_dependencies;
+
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_derived_mode_check;
+ALTER TABLE segments DROP COLUMN IF EXISTS derived_mode;
+ALTER TABLE segments DROP COLUMN IF EXISTS derived_expr;
@@ -0,0 +1,19 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS derived_expr TEXT;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS derived_mode TEXT;
+
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_derived_mode_check;
+ALTER TABLE segments ADD CONSTRAINT segments_derived_mode_check
+       CHECK (derived_mode IN ('snapshot', 'live'));
+
+-- Сегменты, на которые ссылается выражение производного сегмента
+CREATE TABLE IF NOT EXISTS segment_dependencies (
+       segment_id TEXT NOT NULL,
+       source_id TEXT NOT NULL,
+       PRIMARY KEY (segment_id, source_id),
+       CONSTRAINT segment_dependencies_segment_id_fk FOREIGN KEY (segment_id)
+              REFERENCES segments(id) ON UPDATE CASCADE ON DELETE CASCADE,
+       CONSTRAINT segment_dependencies_source_id_fk FOREIGN KEY (source_id)
+              REFERENCES segments(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS segment_dependencies_source_id_idx ON segment_dependencies(source_id);
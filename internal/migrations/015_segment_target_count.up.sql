@@ -0,0 +1,2 @@
+-- Целевое число пользователей при распространении по количеству. NULL - распространение по проценту
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS target_count BIGINT;
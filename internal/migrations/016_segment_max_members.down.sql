@@ -0,0 +1,3 @@
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_max_members_derived_check;
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_max_members_check;
+ALTER TABLE segments DROP COLUMN IF EXISTS max_members;
@@ -0,0 +1,11 @@
+-- Максимальное число участников сегмента на всех шардах вместе. NULL - без ограничения
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS max_members BIGINT;
+
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_max_members_check;
+ALTER TABLE segments ADD CONSTRAINT segments_max_members_check
+       CHECK (max_members > 0);
+
+-- Состав производного сегмента определяется выражением, поэтому ограничить его нельзя
+ALTER TABLE segments DROP CONSTRAINT IF EXISTS segments_max_members_derived_check;
+ALTER TABLE segments ADD CONSTRAINT segments_max_members_derived_check
+       CHECK (max_members IS NULL OR derived_expr IS NULL);
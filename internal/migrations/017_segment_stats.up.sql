@@ -0,0 +1,10 @@
+-- Ежедневные снимки числа участников сегмента на шарде. Каждый шард хранит снимки своих пользователей
+CREATE TABLE IF NOT EXISTS segment_stats (
+       segment_id TEXT NOT NULL,
+       day DATE NOT NULL,
+       users_num BIGINT NOT NULL,
+       taken_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+       PRIMARY KEY (segment_id, day),
+       CONSTRAINT segment_stats_segment_id_fk FOREIGN KEY (segment_id)
+              REFERENCES segments(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+-- Принудительное включение или исключение пользователя из сегмента. Хранится в шарде пользователя
+CREATE TABLE IF NOT EXISTS segment_overrides (
+       user_id INT NOT NULL,
+       segment_id TEXT NOT NULL,
+       action TEXT NOT NULL CHECK (action IN ('include', 'exclude')),
+       expires_at TIMESTAMPTZ,
+       created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+       PRIMARY KEY (user_id, segment_id),
+       CONSTRAINT segment_overrides_user_id_fk FOREIGN KEY (user_id)
+              REFERENCES users(id) ON DELETE CASCADE,
+       CONSTRAINT segment_overrides_segment_id_fk FOREIGN KEY (segment_id)
+              REFERENCES segments(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
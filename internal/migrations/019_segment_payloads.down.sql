@@ -0,0 +1,3 @@
+ALTER TABLE segment_variants DROP COLUMN IF EXISTS payload;
+ALTER TABLE segments DROP COLUMN IF EXISTS payload_schema;
+ALTER TABLE segments DROP COLUMN IF EXISTS payload;
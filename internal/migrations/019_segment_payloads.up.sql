@@ -0,0 +1,6 @@
+-- Параметры сегмента, которые выдаются клиентам вместе с членством, и JSON Schema, по которой они проверяются
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS payload JSONB;
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS payload_schema JSONB;
+
+-- Параметры варианта заменяют параметры сегмента для пользователей этого варианта
+ALTER TABLE segment_variants ADD COLUMN IF NOT EXISTS payload JSONB;
@@ -0,0 +1,24 @@
+DROP TRIGGER IF EXISTS users_segments_history_trg ON users_segments;
+
+CREATE OR REPLACE FUNCTION log_users_segments_change() RETURNS TRIGGER AS $$
+DECLARE
+       change_reason TEXT := COALESCE(NULLIF(current_setting('segmentation.reason', true), ''), 'unknown');
+BEGIN
+       IF TG_OP = 'INSERT' THEN
+              INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+              VALUES (NEW.user_id, NEW.segment_id, 'add', change_reason);
+              RETURN NEW;
+       END IF;
+
+       INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+       VALUES (OLD.user_id, OLD.segment_id, 'remove', change_reason);
+       RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_segments_history_trg
+       AFTER INSERT OR DELETE ON users_segments
+       FOR EACH ROW EXECUTE FUNCTION log_users_segments_change();
+
+DELETE FROM users_segments_history WHERE action = 'rename';
+ALTER TABLE users_segments_history DROP COLUMN IF EXISTS previous_segment_id;
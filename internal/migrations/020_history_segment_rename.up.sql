@@ -0,0 +1,33 @@
+-- Прежний id сегмента в записях о его переименовании
+ALTER TABLE users_segments_history ADD COLUMN IF NOT EXISTS previous_segment_id TEXT;
+
+-- Переименование сегмента каскадно меняет segment_id в users_segments: каждому участнику пишется запись 'rename'
+CREATE OR REPLACE FUNCTION log_users_segments_change() RETURNS TRIGGER AS $$
+DECLARE
+       change_reason TEXT := COALESCE(NULLIF(current_setting('segmentation.reason', true), ''), 'unknown');
+BEGIN
+       IF TG_OP = 'INSERT' THEN
+              INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+              VALUES (NEW.user_id, NEW.segment_id, 'add', change_reason);
+              RETURN NEW;
+       END IF;
+
+       IF TG_OP = 'UPDATE' THEN
+              IF NEW.segment_id <> OLD.segment_id THEN
+                     INSERT INTO users_segments_history (user_id, segment_id, previous_segment_id, action, reason)
+                     VALUES (NEW.user_id, NEW.segment_id, OLD.segment_id, 'rename', change_reason);
+              END IF;
+              RETURN NEW;
+       END IF;
+
+       INSERT INTO users_segments_history (user_id, segment_id, action, reason)
+       VALUES (OLD.user_id, OLD.segment_id, 'remove', change_reason);
+       RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_segments_history_trg ON users_segments;
+
+CREATE TRIGGER users_segments_history_trg
+       AFTER INSERT OR DELETE OR UPDATE OF segment_id ON users_segments
+       FOR EACH ROW EXECUTE FUNCTION log_users_segments_change();
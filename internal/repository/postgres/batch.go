@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"main/internal/domain/models"
+	"sync"
+)
+
+/*
+	GetManyUserSegments - сегменты нескольких пользователей.
+
+Пользователи группируются по шардам, в каждый шард уходит один запрос, шарды опрашиваются параллельно.
+Несуществующих пользователей нет в ответе, у существующих без сегментов - пустой список.
+Как и в GetUserSegments, в ответ попадают сегменты с переопределениями пользователей
+*/
+func (s *SegmentationStorage) GetManyUserSegments(userIds []int) (map[int][]models.Segment, error) {
+	type result struct {
+		segments map[int][]models.Segment
+		err      error
+	}
+
+	ctx := context.Background()
+	byShard := s.groupByShard(userIds)
+	resultCh := make(chan result, len(byShard))
+	wg := sync.WaitGroup{}
+
+	for shardID, ids := range byShard {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB, ids []int) {
+			defer wg.Done()
+
+			segments, err := queryManyUserSegments(ctx, db, ids)
+			if err != nil {
+				resultCh <- result{err: fmt.Errorf("shard %d: %w", shardID, err)}
+				return
+			}
+
+			resultCh <- result{segments: segments}
+		}(shardID, s.dbShards[shardID], ids)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	res := make(map[int][]models.Segment, len(userIds))
+	for r := range resultCh {
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		for id, segs := range r.segments {
+			res[id] = segs
+		}
+	}
+
+	return res, nil
+}
+
+// queryManyUserSegments - сегменты пользователей ids одного шарда
+func queryManyUserSegments(ctx context.Context, db *sql.DB, ids []int) (map[int][]models.Segment, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, seg.id, COALESCE(seg.description, ''), seg.starts_at, seg.expires_at,
+		       COALESCE(seg.status, ''), COALESCE(us.variant, ''),
+		       COALESCE(o.action, ''), o.expires_at, us.segment_id IS NULL, COALESCE(v.payload, seg.payload)
+		FROM users u
+		LEFT JOIN ((SELECT user_id, segment_id, variant FROM users_segments WHERE user_id = ANY($1)) us
+		           FULL JOIN (SELECT user_id, segment_id, action, expires_at FROM segment_overrides WHERE user_id = ANY($1)) o
+		                ON o.user_id = us.user_id AND o.segment_id = us.segment_id)
+		     ON COALESCE(us.user_id, o.user_id) = u.id
+		LEFT JOIN segments seg ON seg.id = COALESCE(us.segment_id, o.segment_id)
+		LEFT JOIN segment_variants v ON v.segment_id = seg.id AND v.name = us.variant
+		WHERE u.id = ANY($1)
+	`, pq.Array(models.UserIdsToInt64(ids)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user segments: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[int][]models.Segment, len(ids))
+	for rows.Next() {
+		var userId int
+		var segId sql.NullString
+		var seg models.Segment
+
+		if err := rows.Scan(&userId, &segId, &seg.Description, &seg.StartsAt, &seg.ExpiresAt, &seg.Status, &seg.Variant,
+			&seg.Override, &seg.OverrideExpiresAt, &seg.OverrideOnly, (*[]byte)(&seg.Payload)); err != nil {
+			return nil, fmt.Errorf("failed to scan segment: %w", err)
+		}
+
+		if _, ok := res[userId]; !ok {
+			res[userId] = []models.Segment{}
+		}
+
+		if segId.Valid {
+			seg.Id = segId.String
+			res[userId] = append(res[userId], seg)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return res, nil
+}
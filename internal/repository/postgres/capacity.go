@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	apperrors "main/internal/errors"
+)
+
+/*
+	capacityShard - шард, в котором блокировка строки сегмента упорядочивает все добавления в сегмент с лимитом участников.
+
+Распределенные транзакции обходят шарды по возрастанию id, поэтому это шард с наименьшим id: пока он заблокирован,
+никто другой не добавляет участников на остальных шардах, и их число можно посчитать по всем шардам
+*/
+func (s *SegmentationStorage) capacityShard() int {
+	res := -1
+
+	for shardID := range s.dbShards {
+		if res == -1 || shardID < res {
+			res = shardID
+		}
+	}
+
+	return res
+}
+
+// segmentMaxMembers - заблокировать строку сегмента id до конца транзакции q и вернуть его лимит участников
+func segmentMaxMembers(ctx context.Context, q querier, id string) (sql.NullInt64, error) {
+	var maxMembers sql.NullInt64
+
+	err := q.QueryRowContext(ctx, "SELECT max_members FROM segments WHERE id = $1 FOR UPDATE", id).Scan(&maxMembers)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.NullInt64{}, apperrors.ErrSegmentNotFound
+		}
+
+		return sql.NullInt64{}, fmt.Errorf("failed to read segment member limit: %w", err)
+	}
+
+	return maxMembers, nil
+}
+
+/*
+	lockCapacity - заблокировать сегмент id в шарде capacityShard транзакцией q и вернуть,
+	сколько еще участников можно в него добавить.
+
+Вернет nil, если у сегмента нет лимита. Блокировка держится до конца транзакции q
+*/
+func (s *SegmentationStorage) lockCapacity(ctx context.Context, q querier, id string) (*int64, error) {
+	maxMembers, err := segmentMaxMembers(ctx, q, id)
+	if err != nil || !maxMembers.Valid {
+		return nil, err
+	}
+
+	counts, err := s.countMembers(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := max(maxMembers.Int64-counts[id], 0)
+
+	return &remaining, nil
+}
+
+/*
+	lockCapacities - заблокировать лимиты сегментов ids перед добавлением в них пользователя из шарда shardID
+	транзакцией tx. Сегменты ids должны быть упорядочены по id, чтобы блокировки брались в одном порядке.
+
+Если шард пользователя не capacityShard, блокировки берутся отдельной транзакцией в capacityShard,
+которую функция release завершает - ее нужно вызвать после фиксации tx. Возвращает оставшуюся емкость сегментов с лимитом
+*/
+func (s *SegmentationStorage) lockCapacities(ctx context.Context, tx *sql.Tx, shardID int, ids []string) (map[string]int64, func(), error) {
+	res := make(map[string]int64)
+	release := func() {}
+
+	if len(ids) == 0 {
+		return res, release, nil
+	}
+
+	var q querier = tx
+	if capShard := s.capacityShard(); capShard != shardID {
+		lockTx, err := s.dbShards[capShard].BeginTx(ctx, nil)
+		if err != nil {
+			return nil, release, fmt.Errorf("shard %d: failed to begin capacity lock: %w", capShard, err)
+		}
+
+		q = lockTx
+		release = func() { _ = lockTx.Rollback() }
+	}
+
+	for _, id := range ids {
+		remaining, err := s.lockCapacity(ctx, q, id)
+		if err != nil && !errors.Is(err, apperrors.ErrSegmentNotFound) {
+			return nil, release, err
+		}
+
+		if remaining != nil {
+			res[id] = *remaining
+		}
+	}
+
+	return res, release, nil
+}
+
+/*
+	capacityQuotas - сколько пользователей можно добавить на каждом шарде при распространении сегмента с лимитом maxMembers.
+
+После распространения на шарде остаются members - removed прежних участников. Если новых кандидатов больше
+свободных мест, места делятся между шардами пропорционально числу кандидатов
+*/
+func capacityQuotas(maxMembers int64, previews map[int]shardPreview) map[int]int64 {
+	candidates := make(map[int]int64, len(previews))
+	var kept, total int64
+
+	for shardID, p := range previews {
+		kept += p.members - p.removed
+		total += p.added
+		candidates[shardID] = p.added
+	}
+
+	free := max(maxMembers-kept, 0)
+	if total <= free {
+		return candidates
+	}
+
+	return splitProportionally(free, candidates, total)
+}
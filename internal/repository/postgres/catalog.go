@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"main/internal/domain/models"
+	"strings"
+	"sync"
+)
+
+// likeEscaper - экранирование спецсимволов LIKE в пользовательской строке
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+/*
+	ListSegments - страница каталога сегментов по возрастанию id.
+
+Каталог одинаков на всех шардах, поэтому читается из одного. Число участников, если оно нужно,
+собирается со всех шардов параллельно
+*/
+func (s *SegmentationStorage) ListSegments(filter models.SegmentsFilter) (models.SegmentsPage, error) {
+	ctx := context.Background()
+
+	rows, err := s.dbShards[0].QueryContext(ctx, `
+		SELECT id, COALESCE(description, ''), starts_at, expires_at, status, COALESCE(layer_id, '')
+		FROM segments
+		WHERE id > $1
+		  AND ($2 = '' OR id LIKE $2 || '%')
+		  AND ($3 = '' OR description ILIKE '%' || $3 || '%')
+		ORDER BY id
+		LIMIT $4
+	`, filter.After, likeEscaper.Replace(filter.IdPrefix), likeEscaper.Replace(filter.DescriptionContains), filter.Limit+1)
+	if err != nil {
+		return models.SegmentsPage{}, fmt.Errorf("failed to query segments: %w", err)
+	}
+	defer rows.Close()
+
+	page := models.SegmentsPage{Segments: []models.SegmentInfo{}}
+	for rows.Next() {
+		var si models.SegmentInfo
+		if err := rows.Scan(&si.Id, &si.Description, &si.StartsAt, &si.ExpiresAt, &si.Status, &si.LayerId); err != nil {
+			return models.SegmentsPage{}, fmt.Errorf("failed to scan segment: %w", err)
+		}
+		page.Segments = append(page.Segments, si)
+	}
+	if err := rows.Err(); err != nil {
+		return models.SegmentsPage{}, fmt.Errorf("rows error: %w", err)
+	}
+
+	if len(page.Segments) > filter.Limit {
+		page.Segments = page.Segments[:filter.Limit]
+		page.Next = page.Segments[filter.Limit-1].Id
+	}
+
+	if !filter.WithCounts || len(page.Segments) == 0 {
+		return page, nil
+	}
+
+	ids := make([]string, len(page.Segments))
+	for i, si := range page.Segments {
+		ids[i] = si.Id
+	}
+
+	counts, err := s.countMembers(ctx, ids)
+	if err != nil {
+		return models.SegmentsPage{}, err
+	}
+
+	for i := range page.Segments {
+		page.Segments[i].UsersNum = counts[page.Segments[i].Id]
+	}
+
+	return page, nil
+}
+
+// countMembers - число участников сегментов ids, сложенное по всем шардам
+func (s *SegmentationStorage) countMembers(ctx context.Context, ids []string) (map[string]int64, error) {
+	type result struct {
+		counts map[string]int64
+		err    error
+	}
+
+	resultCh := make(chan result, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			rows, err := db.QueryContext(ctx,
+				"SELECT segment_id, COUNT(*) FROM users_segments WHERE segment_id = ANY($1) GROUP BY segment_id",
+				pq.Array(ids))
+			if err != nil {
+				resultCh <- result{err: fmt.Errorf("shard %d: failed to count members: %w", shardID, err)}
+				return
+			}
+			defer rows.Close()
+
+			counts := make(map[string]int64)
+			for rows.Next() {
+				var id string
+				var cnt int64
+				if err := rows.Scan(&id, &cnt); err != nil {
+					resultCh <- result{err: fmt.Errorf("shard %d: failed to scan members count: %w", shardID, err)}
+					return
+				}
+				counts[id] = cnt
+			}
+
+			if err := rows.Err(); err != nil {
+				resultCh <- result{err: fmt.Errorf("shard %d: rows error: %w", shardID, err)}
+				return
+			}
+
+			resultCh <- result{counts: counts}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	total := make(map[string]int64, len(ids))
+	for res := range resultCh {
+		if res.err != nil {
+			return nil, res.err
+		}
+
+		for id, cnt := range res.counts {
+			total[id] += cnt
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,270 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"main/internal/domain/models"
+	"main/internal/domain/setexpr"
+	apperrors "main/internal/errors"
+)
+
+// querier - общий интерфейс sql.Conn и sql.Tx для запросов с чтением результата
+type querier interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// derivedSegment - производный сегмент и его выражение
+type derivedSegment struct {
+	id   string
+	expr string
+}
+
+/*
+	CreateDerivedSegment - создать сегмент, состав которого вычисляется выражением expr над существующими сегментами.
+
+Пользователи хранятся в шарде вместе со своими членствами, поэтому выражение вычисляется в каждом шарде отдельно,
+а все шарды меняются одной распределенной транзакцией. Возвращает число попавших в сегмент пользователей
+*/
+func (s *SegmentationStorage) CreateDerivedSegment(segment models.Segment, expr *setexpr.Expr, mode string) (int64, error) {
+	var usersNum int64
+
+	err := s.inTwoPhaseTx(s.allShards(), func(shardID int, conn *sql.Conn) error {
+		ctx := context.Background()
+		sources := expr.Segments()
+
+		var found int
+		err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM segments WHERE id = ANY($1)", pq.Array(sources)).Scan(&found)
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to check source segments: %w", shardID, err)
+		}
+
+		if found != len(sources) {
+			return apperrors.ErrSourceNotFound
+		}
+
+		_, err = conn.ExecContext(ctx, `
+			INSERT INTO segments (id, description, starts_at, expires_at, salt, status, derived_expr, derived_mode)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, segment.Id, segment.Description, segment.StartsAt, segment.ExpiresAt, segment.Salt, segment.Status, expr.String(), mode)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return apperrors.ErrSegmentAlreadyExists
+			}
+
+			return fmt.Errorf("shard %d: insert failed: %w", shardID, err)
+		}
+
+		_, err = conn.ExecContext(ctx, `
+			INSERT INTO segment_dependencies (segment_id, source_id) SELECT $1, unnest($2::text[])
+		`, segment.Id, pq.Array(sources))
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to save dependencies: %w", shardID, err)
+		}
+
+		if err := setChangeReason(ctx, conn, models.HistoryReasonDerivation); err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		added, _, err := s.recomputeDerived(ctx, conn, segment.Id, expr)
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		usersNum += added
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return usersNum, nil
+}
+
+/*
+	recomputeDerived - привести состав производного сегмента id в шарде к результату выражения expr.
+
+Трогаются только участники, добавленные выражением: вручную добавленные пользователи остаются.
+Пользователи глобальной контрольной группы не попадают в производный сегмент, даже если состоят в исходных
+*/
+func (s *SegmentationStorage) recomputeDerived(ctx context.Context, q querier, id string, expr *setexpr.Expr) (int64, int64, error) {
+	query, args := expr.SQL([]any{id, models.MemberSourceDerived})
+	inHoldout, args := s.holdout.SQL("d.user_id", args)
+	query = fmt.Sprintf("(SELECT d.user_id FROM %s d WHERE NOT %s)", query, inHoldout)
+
+	result, err := q.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO users_segments (user_id, segment_id, source)
+		SELECT d.user_id, $1, $2 FROM %s d
+		ON CONFLICT DO NOTHING
+	`, query), args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to insert derived members of %s: %w", id, err)
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	result, err = q.ExecContext(ctx, fmt.Sprintf(`
+		DELETE FROM users_segments
+		WHERE segment_id = $1 AND source = $2 AND user_id NOT IN %s
+	`, query), args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete derived members of %s: %w", id, err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	return added, removed, nil
+}
+
+// liveDependents - пересчитываемые производные сегменты, выражения которых ссылаются на сегменты sourceIds
+func liveDependents(ctx context.Context, q querier, sourceIds []string) ([]derivedSegment, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT DISTINCT seg.id, seg.derived_expr
+		FROM segment_dependencies d
+		JOIN segments seg ON seg.id = d.segment_id
+		WHERE d.source_id = ANY($1) AND seg.derived_mode = $2
+		ORDER BY seg.id
+	`, pq.Array(sourceIds), models.DerivedModeLive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query derived segments: %w", err)
+	}
+	defer rows.Close()
+
+	res := make([]derivedSegment, 0)
+	for rows.Next() {
+		var ds derivedSegment
+		if err := rows.Scan(&ds.id, &ds.expr); err != nil {
+			return nil, fmt.Errorf("failed to scan derived segment: %w", err)
+		}
+		res = append(res, ds)
+	}
+
+	return res, rows.Err()
+}
+
+/*
+	refreshDerived - пересчитать в шарде пересчитываемые производные сегменты, зависящие от изменившихся changedIds,
+	и, по цепочке, зависящие от них.
+
+Меняет причину изменения членства в транзакции, поэтому вызывается последним изменением членства в ней
+*/
+func (s *SegmentationStorage) refreshDerived(ctx context.Context, q querier, changedIds []string) error {
+	dependents, err := liveDependents(ctx, q, changedIds)
+	if err != nil {
+		return err
+	}
+
+	return s.refreshSegments(ctx, q, dependents)
+}
+
+// refreshSegments - пересчитать производные сегменты segs и все пересчитываемые сегменты, зависящие от них
+func (s *SegmentationStorage) refreshSegments(ctx context.Context, q querier, segs []derivedSegment) error {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	if err := setChangeReason(ctx, q, models.HistoryReasonDerivation); err != nil {
+		return err
+	}
+
+	visited := make(map[string]bool)
+
+	for len(segs) > 0 {
+		changed := make([]string, 0)
+
+		for _, ds := range segs {
+			if visited[ds.id] {
+				continue
+			}
+			visited[ds.id] = true
+
+			expr, err := setexpr.Parse(ds.expr)
+			if err != nil {
+				return fmt.Errorf("failed to parse expression of derived segment %s: %w", ds.id, err)
+			}
+
+			added, removed, err := s.recomputeDerived(ctx, q, ds.id, expr)
+			if err != nil {
+				return err
+			}
+
+			if added > 0 || removed > 0 {
+				changed = append(changed, ds.id)
+			}
+		}
+
+		if len(changed) == 0 {
+			return nil
+		}
+
+		next, err := liveDependents(ctx, q, changed)
+		if err != nil {
+			return err
+		}
+
+		segs = next
+	}
+
+	return nil
+}
+
+/*
+	renameInExpressions - заменить в выражениях производных сегментов ссылки на переименованный сегмент.
+
+Вызывается после переименования, когда segment_dependencies уже указывает на newId
+*/
+func renameInExpressions(ctx context.Context, q querier, oldId, newId string) error {
+	rows, err := q.QueryContext(ctx, `
+		SELECT seg.id, seg.derived_expr
+		FROM segment_dependencies d
+		JOIN segments seg ON seg.id = d.segment_id
+		WHERE d.source_id = $1
+	`, newId)
+	if err != nil {
+		return fmt.Errorf("failed to query derived segments: %w", err)
+	}
+
+	dependents := make([]derivedSegment, 0)
+	for rows.Next() {
+		var ds derivedSegment
+		if err := rows.Scan(&ds.id, &ds.expr); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan derived segment: %w", err)
+		}
+		dependents = append(dependents, ds)
+	}
+
+	err = rows.Err()
+	rows.Close()
+
+	if err != nil {
+		return fmt.Errorf("rows error: %w", err)
+	}
+
+	for _, ds := range dependents {
+		expr, err := setexpr.Parse(ds.expr)
+		if err != nil {
+			return fmt.Errorf("failed to parse expression of derived segment %s: %w", ds.id, err)
+		}
+
+		_, err = q.ExecContext(ctx, "UPDATE segments SET derived_expr = $1 WHERE id = $2", expr.Rename(oldId, newId).String(), ds.id)
+		if err != nil {
+			return fmt.Errorf("failed to update expression of derived segment %s: %w", ds.id, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/domain/rules"
+)
+
+/*
+	autoEnroll - добавить только что созданного пользователя в сегменты с автодобавлением.
+
+Выполняется в транзакции создания пользователя. Для каждого такого сегмента проверяются те же условия,
+что и при распространении: бакет пользователя меньше целевого и пользователь подходит под условие сегмента.
+Из нескольких подходящих сегментов одного слоя пользователь попадает в первый по id.
+Пользователь из глобальной контрольной группы никуда не добавляется.
+В заполненные до лимита сегменты пользователь не добавляется. Лимиты блокируются до конца создания пользователя:
+release нужно вызвать после фиксации tx, в том числе при ошибке
+*/
+func (s *SegmentationStorage) autoEnroll(ctx context.Context, tx *sql.Tx, userId int, attrs map[string]any) (release func(), err error) {
+	release = func() {}
+
+	if s.holdout.Contains(userId) {
+		return release, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, COALESCE(bucket_key, id), salt, target_buckets, rule, max_members IS NOT NULL
+		FROM segments
+		WHERE auto_enroll AND target_buckets > 0 AND status <> 'archived'
+		  AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY id
+	`)
+	if err != nil {
+		return release, fmt.Errorf("failed to query auto-enroll segments: %w", err)
+	}
+
+	segmentIds := make([]string, 0)
+	cappedIds := make([]string, 0)
+
+	for rows.Next() {
+		var id, bucketKey, salt, ruleSrc string
+		var targetBuckets int
+		var capped bool
+
+		if err := rows.Scan(&id, &bucketKey, &salt, &targetBuckets, &ruleSrc, &capped); err != nil {
+			rows.Close()
+			return release, fmt.Errorf("failed to scan auto-enroll segment: %w", err)
+		}
+
+		if bucketing.Bucket(bucketKey, salt, userId) >= targetBuckets {
+			continue
+		}
+
+		var rule *rules.Rule
+		if ruleSrc != "" {
+			rule, err = rules.Parse(ruleSrc)
+			if err != nil {
+				s.log.Error("failed to parse segment rule", slog.String("id", id), slog.String("error", err.Error()))
+				continue
+			}
+		}
+
+		if rule.Match(attrs) {
+			segmentIds = append(segmentIds, id)
+			if capped {
+				cappedIds = append(cappedIds, id)
+			}
+		}
+	}
+
+	err = rows.Err()
+	rows.Close()
+
+	if err != nil {
+		return release, fmt.Errorf("rows error: %w", err)
+	}
+
+	if len(segmentIds) == 0 {
+		return release, nil
+	}
+
+	// Блокировки лимитов берутся до первой вставки, чтобы не ждать их, держа блокировки строк сегментов
+	capacities, release, err := s.lockCapacities(ctx, tx, userId%s.shardsNum, cappedIds)
+	if err != nil {
+		return release, err
+	}
+
+	if err := setChangeReason(ctx, tx, models.HistoryReasonAutoEnrollment); err != nil {
+		return release, err
+	}
+
+	enrolled := make([]string, 0, len(segmentIds))
+
+	for _, segmentId := range segmentIds {
+		if remaining, ok := capacities[segmentId]; ok && remaining == 0 {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO users_segments (user_id, segment_id, source) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, userId, segmentId, models.MemberSourceDistribution)
+		if err != nil {
+			return release, fmt.Errorf("failed to auto-enroll user to segment %s: %w", segmentId, err)
+		}
+
+		enrolled = append(enrolled, segmentId)
+	}
+
+	return release, s.refreshDerived(ctx, tx, enrolled)
+}
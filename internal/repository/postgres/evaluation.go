@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+)
+
+/*
+	GetSegmentsTargeting - все сегменты с настройками распространения и вариантами для оценки пользователя userId в памяти.
+
+Сегменты одинаковы во всех шардах, поэтому читаются из шарда пользователя вместе с его переопределениями.
+Сам пользователь может не существовать. Для сегментов с лимитом участников считается их число по всем шардам.
+Сегменты упорядочены по id
+*/
+func (s *SegmentationStorage) GetSegmentsTargeting(userId int) ([]models.SegmentTargeting, error) {
+	ctx := context.Background()
+	shardID := userId % s.shardsNum
+	db := s.dbShards[shardID]
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT seg.id, seg.starts_at, seg.expires_at, seg.salt, seg.status, COALESCE(seg.layer_id, ''),
+		       COALESCE(seg.bucket_key, seg.id), seg.target_buckets, seg.target_count, seg.rule, seg.auto_enroll, seg.max_members,
+		       COALESCE(seg.derived_expr, ''), COALESCE(seg.derived_mode, ''), COALESCE(o.action, ''), o.expires_at
+		FROM segments seg
+		LEFT JOIN segment_overrides o ON o.segment_id = seg.id AND o.user_id = $1
+		ORDER BY seg.id
+	`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: failed to query segments targeting: %w", shardID, err)
+	}
+	defer rows.Close()
+
+	res := make([]models.SegmentTargeting, 0)
+	cappedIds := make([]string, 0)
+
+	for rows.Next() {
+		var t models.SegmentTargeting
+		var targetCount, maxMembers sql.NullInt64
+
+		err := rows.Scan(&t.Id, &t.StartsAt, &t.ExpiresAt, &t.Salt, &t.Status, &t.LayerId,
+			&t.BucketKey, &t.TargetBuckets, &targetCount, &t.Rule, &t.AutoEnroll, &maxMembers,
+			&t.DerivedExpr, &t.DerivedMode, &t.Override, &t.OverrideExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: failed to scan segment targeting: %w", shardID, err)
+		}
+
+		if targetCount.Valid {
+			t.TargetCount = &targetCount.Int64
+		}
+
+		if maxMembers.Valid {
+			t.MaxMembers = &maxMembers.Int64
+			cappedIds = append(cappedIds, t.Id)
+		}
+
+		res = append(res, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("shard %d: rows error: %w", shardID, err)
+	}
+
+	if len(cappedIds) > 0 {
+		counts, err := s.countMembers(ctx, cappedIds)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range res {
+			res[i].Members = counts[res[i].Id]
+		}
+	}
+
+	variants, err := allVariants(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", shardID, err)
+	}
+
+	for i := range res {
+		res[i].Variants = variants[res[i].Id]
+	}
+
+	return res, nil
+}
+
+// allVariants - варианты всех сегментов в порядке их задания
+func allVariants(ctx context.Context, db *sql.DB) (map[string][]models.Variant, error) {
+	rows, err := db.QueryContext(ctx, "SELECT segment_id, name, weight FROM segment_variants ORDER BY segment_id, ord")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query variants: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[string][]models.Variant)
+	for rows.Next() {
+		var segmentId string
+		var v models.Variant
+
+		if err := rows.Scan(&segmentId, &v.Name, &v.Weight); err != nil {
+			return nil, fmt.Errorf("failed to scan variant: %w", err)
+		}
+
+		res[segmentId] = append(res[segmentId], v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return res, nil
+}
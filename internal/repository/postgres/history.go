@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+	"time"
+)
+
+// execer - общий интерфейс sql.Conn и sql.Tx для выполнения запросов
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+/*
+	setChangeReason - задать причину изменения членства в сегментах для текущей транзакции.
+
+Причину читает триггер на users_segments, который пишет историю, в том числе при каскадных удалениях
+*/
+func setChangeReason(ctx context.Context, ex execer, reason string) error {
+	_, err := ex.ExecContext(ctx, "SELECT set_config('segmentation.reason', $1, true)", reason)
+	if err != nil {
+		return fmt.Errorf("failed to set change reason: %w", err)
+	}
+
+	return nil
+}
+
+/*
+	GetUserSegmentHistory - получить историю членства пользователя в сегментах за период [from, to).
+
+Нулевые from и to не ограничивают период. История хранится и после удаления пользователя или сегмента.
+После переименования сегмента вся его история числится под новым id
+*/
+func (s *SegmentationStorage) GetUserSegmentHistory(id int, from, to time.Time) ([]models.HistoryEntry, error) {
+	ctx := context.Background()
+	shardNum := id % s.shardsNum
+	db := s.dbShards[shardNum]
+
+	var fromArg, toArg any
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, segment_id, COALESCE(previous_segment_id, ''), action, reason, created_at
+		FROM users_segments_history
+		WHERE user_id = $1
+		  AND ($2::timestamptz IS NULL OR created_at >= $2)
+		  AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY created_at, id
+	`, id, fromArg, toArg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user segment history: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.HistoryEntry{}
+	for rows.Next() {
+		var e models.HistoryEntry
+		if err := rows.Scan(&e.UserId, &e.SegmentId, &e.PreviousSegmentId, &e.Action, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan history entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return entries, nil
+}
+
+/*
+	renameInHistory - перенести историю членства в сегменте oldId на его новый id newId.
+
+Записи о самом переименовании уже записаны триггером под newId и хранят oldId как прежний id
+*/
+func renameInHistory(ctx context.Context, ex execer, oldId, newId string) error {
+	_, err := ex.ExecContext(ctx, "UPDATE users_segments_history SET segment_id = $1 WHERE segment_id = $2", newId, oldId)
+	if err != nil {
+		return fmt.Errorf("failed to rename segment in history: %w", err)
+	}
+
+	return nil
+}
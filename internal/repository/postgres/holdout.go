@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+	"sort"
+	"sync"
+)
+
+// InHoldout - проверка, что пользователь id в глобальной контрольной группе
+func (s *SegmentationStorage) InHoldout(id int) bool {
+	return s.holdout.Contains(id)
+}
+
+// GetHoldoutInfo - доля глобальной контрольной группы и ее размер на каждом шарде
+func (s *SegmentationStorage) GetHoldoutInfo() (models.HoldoutInfo, error) {
+	type result struct {
+		shard models.ShardHoldout
+		err   error
+	}
+
+	ctx := context.Background()
+	resultCh := make(chan result, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	inHoldout, args := s.holdout.SQL("u.id", nil)
+	query := fmt.Sprintf("SELECT COUNT(*), COUNT(*) FILTER (WHERE %s) FROM users u", inHoldout)
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			sh := models.ShardHoldout{ShardId: shardID}
+			if err := db.QueryRowContext(ctx, query, args...).Scan(&sh.UsersNum, &sh.HoldoutUsers); err != nil {
+				resultCh <- result{err: fmt.Errorf("shard %d: failed to count holdout users: %w", shardID, err)}
+				return
+			}
+
+			resultCh <- result{shard: sh}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	info := models.HoldoutInfo{Percentage: s.holdout.Percentage(), Shards: []models.ShardHoldout{}}
+	for res := range resultCh {
+		if res.err != nil {
+			return models.HoldoutInfo{}, res.err
+		}
+
+		info.Shards = append(info.Shards, res.shard)
+	}
+
+	sort.Slice(info.Shards, func(i, j int) bool { return info.Shards[i].ShardId < info.Shards[j].ShardId })
+
+	return info, nil
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+// CreateLayer - создать слой взаимоисключающих сегментов во всех шардах
+func (s *SegmentationStorage) CreateLayer(layer models.Layer) (string, error) {
+	err := s.inTwoPhaseTx(s.allShards(), func(shardID int, conn *sql.Conn) error {
+		_, err := conn.ExecContext(context.Background(),
+			"INSERT INTO layers (id, description) VALUES ($1, $2)",
+			layer.Id, layer.Description)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return apperrors.ErrLayerAlreadyExists
+			}
+
+			return fmt.Errorf("shard %d: insert failed: %w", shardID, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return layer.Id, nil
+}
+
+// layerUsage - сколько пользователей шарда еще не заняты ни одним сегментом слоя layerId и сколько их всего
+func layerUsage(ctx context.Context, db *sql.DB, layerId string) (free int64, total int64, err error) {
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (
+		           WHERE NOT EXISTS (SELECT 1 FROM users_segments us WHERE us.user_id = u.id AND us.layer_id = $1)
+		       ),
+		       COUNT(*)
+		FROM users u
+	`, layerId).Scan(&free, &total)
+
+	return free, total, err
+}
+
+// layerFreeSQL - условие SQL, что пользователь u не занят другим сегментом слоя сегмента seg
+func layerFreeSQL() string {
+	return `(seg.layer_id IS NULL OR NOT EXISTS (
+		SELECT 1 FROM users_segments taken
+		WHERE taken.user_id = u.id AND taken.layer_id = seg.layer_id AND taken.segment_id <> seg.id
+	))`
+}
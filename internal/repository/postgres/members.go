@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+/*
+	AddUsersToSegment - вручную добавить пользователей userIds в сегмент id.
+
+Записи добавляются только в шарды, где хранятся эти пользователи, одной распределенной транзакцией.
+Неизвестные пользователи, пользователи, уже состоящие в сегменте, и пользователи, занятые другим сегментом
+того же слоя, не считаются ошибкой и возвращаются в результате.
+В транзакции всегда участвует шард capacityShard: под блокировкой сегмента в нем проверяется лимит участников.
+Когда сегмент заполнен, оставшиеся пользователи (с большими id на каждом шарде) возвращаются как отклоненные
+*/
+func (s *SegmentationStorage) AddUsersToSegment(id string, userIds []int) (models.MembershipChange, error) {
+	res := models.MembershipChange{Changed: []int{}, Unknown: []int{}, Unchanged: []int{}, Conflicts: []int{}, Rejected: []int{}}
+	groups := s.groupByShard(userIds)
+	capShard := s.capacityShard()
+	shardIDs := []int{capShard}
+
+	for shardID := range groups {
+		if shardID != capShard {
+			shardIDs = append(shardIDs, shardID)
+		}
+	}
+
+	// capacity - сколько еще участников можно добавить, nil - без лимита
+	var capacity *int64
+
+	err := s.inTwoPhaseTx(shardIDs, func(shardID int, conn *sql.Conn) error {
+		ctx := context.Background()
+		ids := groups[shardID]
+
+		if shardID == capShard {
+			var err error
+			if capacity, err = s.lockCapacity(ctx, conn, id); err != nil {
+				return err
+			}
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := checkSegmentExists(ctx, conn, shardID, id); err != nil {
+			return err
+		}
+
+		if err := setChangeReason(ctx, conn, models.HistoryReasonManual); err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		unknown, err := unknownUsers(ctx, conn, shardID, ids)
+		if err != nil {
+			return err
+		}
+
+		conflicts, err := queryUserIds(ctx, conn, `
+			SELECT us.user_id
+			FROM users_segments us
+			JOIN segments seg ON seg.id = $1
+			WHERE us.user_id = ANY($2) AND seg.layer_id IS NOT NULL
+			  AND us.layer_id = seg.layer_id AND us.segment_id <> seg.id
+		`, id, pq.Array(models.UserIdsToInt64(ids)))
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to check layer conflicts: %w", shardID, err)
+		}
+
+		members, err := queryUserIds(ctx, conn,
+			"SELECT user_id FROM users_segments WHERE segment_id = $1 AND user_id = ANY($2)",
+			id, pq.Array(models.UserIdsToInt64(ids)))
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to check membership: %w", shardID, err)
+		}
+
+		// LIMIT NULL - без ограничения
+		added, err := queryUserIds(ctx, conn, `
+			INSERT INTO users_segments (user_id, segment_id, source)
+			SELECT u.id, $1, $3 FROM users u
+			WHERE u.id = ANY($2) AND u.id <> ALL($4) AND u.id <> ALL($5)
+			ORDER BY u.id
+			LIMIT $6
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		`, id, pq.Array(models.UserIdsToInt64(ids)), models.MemberSourceManual, pq.Array(models.UserIdsToInt64(members)), pq.Array(models.UserIdsToInt64(conflicts)), capacity)
+		if err != nil {
+			return fmt.Errorf("shard %d: insert failed: %w", shardID, err)
+		}
+
+		if capacity != nil {
+			*capacity -= int64(len(added))
+		}
+
+		if len(added) > 0 {
+			if err := s.refreshDerived(ctx, conn, []string{id}); err != nil {
+				return fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}
+
+		res.Changed = append(res.Changed, added...)
+		res.Unknown = append(res.Unknown, unknown...)
+		res.Conflicts = append(res.Conflicts, conflicts...)
+		res.Unchanged = append(res.Unchanged, members...)
+		res.Rejected = append(res.Rejected, subtractIds(ids, unknown, added, conflicts, members)...)
+
+		return nil
+	})
+
+	if err != nil {
+		return models.MembershipChange{}, err
+	}
+
+	res.Sort()
+
+	return res, nil
+}
+
+/*
+	RemoveUsersFromSegment - вручную удалить пользователей userIds из сегмента id.
+
+Затрагиваются только шарды, где хранятся эти пользователи. Неизвестные пользователи и пользователи,
+не состоящие в сегменте, возвращаются в результате
+*/
+func (s *SegmentationStorage) RemoveUsersFromSegment(id string, userIds []int) (models.MembershipChange, error) {
+	res := models.MembershipChange{Changed: []int{}, Unknown: []int{}, Unchanged: []int{}, Conflicts: []int{}}
+	groups := s.groupByShard(userIds)
+	shardIDs := make([]int, 0, len(groups))
+
+	for shardID := range groups {
+		shardIDs = append(shardIDs, shardID)
+	}
+
+	err := s.inTwoPhaseTx(shardIDs, func(shardID int, conn *sql.Conn) error {
+		ctx := context.Background()
+		ids := groups[shardID]
+
+		if err := checkSegmentExists(ctx, conn, shardID, id); err != nil {
+			return err
+		}
+
+		if err := setChangeReason(ctx, conn, models.HistoryReasonManual); err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		unknown, err := unknownUsers(ctx, conn, shardID, ids)
+		if err != nil {
+			return err
+		}
+
+		removed, err := queryUserIds(ctx, conn, `
+			DELETE FROM users_segments
+			WHERE segment_id = $1 AND user_id = ANY($2)
+			RETURNING user_id
+		`, id, pq.Array(models.UserIdsToInt64(ids)))
+		if err != nil {
+			return fmt.Errorf("shard %d: delete failed: %w", shardID, err)
+		}
+
+		if len(removed) > 0 {
+			if err := s.refreshDerived(ctx, conn, []string{id}); err != nil {
+				return fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}
+
+		res.Changed = append(res.Changed, removed...)
+		res.Unknown = append(res.Unknown, unknown...)
+		res.Unchanged = append(res.Unchanged, subtractIds(ids, unknown, removed)...)
+
+		return nil
+	})
+
+	if err != nil {
+		return models.MembershipChange{}, err
+	}
+
+	res.Sort()
+
+	return res, nil
+}
+
+// checkSegmentExists - проверить, что сегмент есть на шарде. Иначе вернет ErrSegmentNotFound
+func checkSegmentExists(ctx context.Context, conn *sql.Conn, shardID int, id string) error {
+	var exists bool
+
+	err := conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM segments WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("shard %d: failed to check segment existence: %w", shardID, err)
+	}
+
+	if !exists {
+		return apperrors.ErrSegmentNotFound
+	}
+
+	return nil
+}
+
+// unknownUsers - выбрать из ids тех пользователей, которых нет на шарде
+func unknownUsers(ctx context.Context, conn *sql.Conn, shardID int, ids []int) ([]int, error) {
+	unknown, err := queryUserIds(ctx, conn, `
+		SELECT r.id FROM unnest($1::int[]) AS r(id)
+		WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = r.id)
+	`, pq.Array(models.UserIdsToInt64(ids)))
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: failed to check users existence: %w", shardID, err)
+	}
+
+	return unknown, nil
+}
+
+// queryUserIds - выполнить запрос, возвращающий один столбец с id пользователей
+func queryUserIds(ctx context.Context, conn *sql.Conn, query string, args ...any) ([]int, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// subtractIds - ids без элементов из excluded
+func subtractIds(ids []int, excluded ...[]int) []int {
+	skip := make(map[int]bool)
+
+	for _, ex := range excluded {
+		for _, id := range ex {
+			skip[id] = true
+		}
+	}
+
+	res := []int{}
+	for _, id := range ids {
+		if !skip[id] {
+			res = append(res, id)
+		}
+	}
+
+	return res
+}
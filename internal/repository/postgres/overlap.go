@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"log/slog"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+	"sort"
+	"sync"
+)
+
+// segmentPair - пара сегментов, a <= b побайтово, как при сравнении строк в Go и COLLATE "C" в postgres
+type segmentPair struct {
+	a, b string
+}
+
+/*
+	GetSegmentOverlap - попарные пересечения сегментов ids по всем шардам.
+
+Каждый шард считает свою часть одним сгруппированным запросом по users_segments, результаты складываются.
+Как и в GetSegmentInfo, шарды, которые не ответили, перечисляются в FailedShards.
+Ошибка, если какого-то сегмента нет или не ответил ни один шард
+*/
+func (s *SegmentationStorage) GetSegmentOverlap(ids []string) (models.SegmentOverlap, error) {
+	type result struct {
+		shardID int
+		counts  map[segmentPair]int64
+		err     error
+	}
+
+	ctx := context.Background()
+	resultCh := make(chan result, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			counts, err := shardOverlap(ctx, db, ids)
+			if err != nil && !errors.Is(err, apperrors.ErrSegmentNotFound) {
+				err = fmt.Errorf("shard %d: %w", shardID, err)
+			}
+
+			resultCh <- result{shardID: shardID, counts: counts, err: err}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	total := make(map[segmentPair]int64)
+	res := models.SegmentOverlap{Ids: ids, FailedShards: []int{}}
+	found := false
+	errorsFound := []error{}
+
+	for r := range resultCh {
+		if errors.Is(r.err, apperrors.ErrSegmentNotFound) {
+			return models.SegmentOverlap{}, apperrors.ErrSegmentNotFound
+		}
+
+		if r.err != nil {
+			errorsFound = append(errorsFound, r.err)
+			res.FailedShards = append(res.FailedShards, r.shardID)
+			continue
+		}
+
+		found = true
+
+		for pair, cnt := range r.counts {
+			total[pair] += cnt
+		}
+	}
+
+	if !found {
+		s.log.Error(fmt.Sprintf("failed to count segment overlap. Errors: %v", errorsFound), slog.Any("ids", ids))
+		return models.SegmentOverlap{}, apperrors.ErrShardUnavailable
+	}
+
+	if len(errorsFound) > 0 {
+		s.log.Warn(fmt.Sprintf("segment overlap is partial. Errors: %v", errorsFound), slog.Any("ids", ids))
+	}
+
+	res.Counts = make([][]int64, len(ids))
+	for i := range ids {
+		res.Counts[i] = make([]int64, len(ids))
+
+		for j := range ids {
+			pair := segmentPair{a: min(ids[i], ids[j]), b: max(ids[i], ids[j])}
+			res.Counts[i][j] = total[pair]
+		}
+	}
+
+	sort.Ints(res.FailedShards)
+
+	return res, nil
+}
+
+// shardOverlap - пересечения сегментов ids на одном шарде. Для каждой пары a <= b, включая a = b
+func shardOverlap(ctx context.Context, db *sql.DB, ids []string) (map[segmentPair]int64, error) {
+	var found int
+
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM segments WHERE id = ANY($1)", pq.Array(ids)).Scan(&found)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check segments existence: %w", err)
+	}
+
+	if found != len(ids) {
+		return nil, apperrors.ErrSegmentNotFound
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.segment_id, b.segment_id, COUNT(*)
+		FROM users_segments a
+		JOIN users_segments b ON b.user_id = a.user_id AND b.segment_id COLLATE "C" >= a.segment_id COLLATE "C"
+		WHERE a.segment_id = ANY($1) AND b.segment_id = ANY($1)
+		GROUP BY a.segment_id, b.segment_id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count segment overlap: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[segmentPair]int64)
+	for rows.Next() {
+		var pair segmentPair
+		var cnt int64
+		if err := rows.Scan(&pair.a, &pair.b, &cnt); err != nil {
+			return nil, fmt.Errorf("failed to scan segment overlap: %w", err)
+		}
+		counts[pair] = cnt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return counts, nil
+}
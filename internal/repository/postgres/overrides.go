@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+/*
+	SetUserOverride - задать переопределение членства пользователя в сегменте.
+
+Переопределение хранится в шарде пользователя, прежнее переопределение той же пары заменяется.
+Состав сегмента не меняется: переопределение учитывается только при выдаче сегментов пользователя
+*/
+func (s *SegmentationStorage) SetUserOverride(override models.Override) error {
+	ctx := context.Background()
+	shardID := override.UserId % s.shardsNum
+	db := s.dbShards[shardID]
+
+	if err := checkOverrideTarget(ctx, db, override.UserId, override.SegmentId); err != nil {
+		return err
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO segment_overrides (user_id, segment_id, action, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, segment_id) DO UPDATE
+		SET action = EXCLUDED.action, expires_at = EXCLUDED.expires_at, created_at = now()
+	`, override.UserId, override.SegmentId, override.Action, override.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("shard %d: failed to save override: %w", shardID, err)
+	}
+
+	return nil
+}
+
+// ClearUserOverride - удалить переопределение членства пользователя userId в сегменте segmentId
+func (s *SegmentationStorage) ClearUserOverride(userId int, segmentId string) error {
+	ctx := context.Background()
+	shardID := userId % s.shardsNum
+	db := s.dbShards[shardID]
+
+	res, err := db.ExecContext(ctx, "DELETE FROM segment_overrides WHERE user_id = $1 AND segment_id = $2", userId, segmentId)
+	if err != nil {
+		return fmt.Errorf("shard %d: failed to delete override: %w", shardID, err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("shard %d: failed to get affected rows: %w", shardID, err)
+	}
+
+	if deleted == 0 {
+		return apperrors.ErrOverrideNotFound
+	}
+
+	return nil
+}
+
+// checkOverrideTarget - проверка, что в шарде есть и пользователь userId, и сегмент segmentId
+func checkOverrideTarget(ctx context.Context, db *sql.DB, userId int, segmentId string) error {
+	var userExists, segmentExists bool
+
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1), EXISTS (SELECT 1 FROM segments WHERE id = $2)
+	`, userId, segmentId).Scan(&userExists, &segmentExists)
+	if err != nil {
+		return fmt.Errorf("failed to check override target: %w", err)
+	}
+
+	if !userExists {
+		return apperrors.ErrUserNotFound
+	}
+
+	if !segmentExists {
+		return apperrors.ErrSegmentNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"main/internal/domain/jsonschema"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+// nullableJSON - параметр запроса для JSON-значения: NULL, если значение не задано
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	return string(raw)
+}
+
+/*
+	updateVariantPayloads - заменить параметры вариантов сегмента id. JSON null удаляет параметры варианта.
+
+Варианты задаются при создании сегмента, поэтому неизвестный вариант - ошибка
+*/
+func updateVariantPayloads(ctx context.Context, conn *sql.Conn, id string, variants []models.Variant) error {
+	for _, v := range variants {
+		result, err := conn.ExecContext(ctx,
+			"UPDATE segment_variants SET payload = NULLIF($1::jsonb, 'null'::jsonb) WHERE segment_id = $2 AND name = $3",
+			nullableJSON(v.Payload), id, v.Name)
+		if err != nil {
+			return fmt.Errorf("failed to update payload of variant %q: %w", v.Name, err)
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+
+		if updated == 0 {
+			return apperrors.ErrVariantNotFound
+		}
+	}
+
+	return nil
+}
+
+/*
+	checkPayloads - проверить параметры сегмента id и его вариантов по схеме сегмента после изменения.
+
+Вызывается в транзакции изменения, поэтому видит новые значения. Без схемы параметры не проверяются
+*/
+func checkPayloads(ctx context.Context, conn *sql.Conn, id string) error {
+	var schemaSrc, payload []byte
+
+	err := conn.QueryRowContext(ctx, "SELECT payload_schema, payload FROM segments WHERE id = $1", id).Scan(&schemaSrc, &payload)
+	if err != nil {
+		return fmt.Errorf("failed to read segment payload: %w", err)
+	}
+
+	if schemaSrc == nil {
+		return nil
+	}
+
+	schema, err := jsonschema.Compile(schemaSrc)
+	if err != nil {
+		return fmt.Errorf("failed to compile stored payload schema: %w", err)
+	}
+
+	payloads := [][]byte{payload}
+
+	rows, err := conn.QueryContext(ctx, "SELECT payload FROM segment_variants WHERE segment_id = $1 AND payload IS NOT NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to read variant payloads: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantPayload []byte
+		if err := rows.Scan(&variantPayload); err != nil {
+			return fmt.Errorf("failed to scan variant payload: %w", err)
+		}
+		payloads = append(payloads, variantPayload)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %w", err)
+	}
+
+	for _, p := range payloads {
+		if p == nil {
+			continue
+		}
+
+		if err := schema.Validate(p); err != nil {
+			return apperrors.ErrPayloadMismatch
+		}
+	}
+
+	return nil
+}
@@ -3,14 +3,18 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 	"log/slog"
+	"main/internal/domain/bucketing"
 	"main/internal/domain/models"
+	"main/internal/domain/rules"
 	apperrors "main/internal/errors"
+	"sort"
 	"sync"
 )
 
@@ -18,10 +22,11 @@ import (
 type SegmentationStorage struct {
 	shardsNum int
 	dbShards  map[int]*sql.DB
+	holdout   bucketing.Holdout // глобальная контрольная группа, не попадающая в сегменты при распространении
 	log       *slog.Logger
 }
 
-func NewSegmentationStorage(numShards int, dsns []string, log *slog.Logger) (*SegmentationStorage, error) {
+func NewSegmentationStorage(numShards int, dsns []string, holdout bucketing.Holdout, log *slog.Logger) (*SegmentationStorage, error) {
 	dbShards := make(map[int]*sql.DB)
 
 	for i, dsn := range dsns {
@@ -43,12 +48,12 @@ func NewSegmentationStorage(numShards int, dsns []string, log *slog.Logger) (*Se
 		dbShards[i] = db
 	}
 
-	segStorage := &SegmentationStorage{shardsNum: numShards, dbShards: dbShards, log: log}
+	segStorage := &SegmentationStorage{shardsNum: numShards, dbShards: dbShards, holdout: holdout, log: log}
 
 	return segStorage, nil
 }
 
-// CreateSegment - создать сегмент во всех шардах
+// CreateSegment - создать сегмент во всех шардах. Если задан слой, он должен уже существовать
 func (s *SegmentationStorage) CreateSegment(segment models.Segment) (string, error) {
 	ctx := context.Background()
 	txID := "tx_" + uuid.New().String()
@@ -69,8 +74,10 @@ func (s *SegmentationStorage) CreateSegment(segment models.Segment) (string, err
 		}
 
 		_, err = conn.ExecContext(ctx,
-			"INSERT INTO segments (id, description) VALUES ($1, $2)",
-			segment.Id, segment.Description)
+			`INSERT INTO segments (id, description, starts_at, expires_at, salt, status, layer_id, max_members, payload, payload_schema)
+			 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10)`,
+			segment.Id, segment.Description, segment.StartsAt, segment.ExpiresAt, segment.Salt, segment.Status, segment.LayerId,
+			segment.MaxMembers, nullableJSON(segment.Payload), nullableJSON(segment.PayloadSchema))
 		if err != nil {
 			var pqErr *pq.Error
 			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
@@ -79,11 +86,34 @@ func (s *SegmentationStorage) CreateSegment(segment models.Segment) (string, err
 				return "", apperrors.ErrSegmentAlreadyExists
 			}
 
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", apperrors.ErrLayerNotFound
+			}
+
 			_, _ = conn.ExecContext(ctx, "ROLLBACK")
 			s.rollbackAll(txID, preparedShards)
 			return "", fmt.Errorf("shard %d: insert failed: %w", shardID, err)
 		}
 
+		if err := insertVariants(ctx, conn, segment.Id, segment.Variants); err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+			return "", fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		if err := checkPayloads(ctx, conn, segment.Id); err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+
+			if errors.Is(err, apperrors.ErrPayloadMismatch) {
+				return "", err
+			}
+
+			return "", fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
 		prepareQuery := fmt.Sprintf("PREPARE TRANSACTION '%s'", txID)
 		if _, err := conn.ExecContext(ctx, prepareQuery); err != nil {
 			s.rollbackAll(txID, preparedShards)
@@ -124,6 +154,19 @@ func (s *SegmentationStorage) DeleteSegment(id string) (string, error) {
 			return "", fmt.Errorf("shard %d: begin failed: %w", shardID, err)
 		}
 
+		if err := setChangeReason(ctx, conn, models.HistoryReasonSegmentDeletion); err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+			return "", fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		dependents, err := liveDependents(ctx, conn, []string{id})
+		if err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+			return "", fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
 		result, err := conn.ExecContext(ctx, "DELETE FROM segments WHERE id = $1", id)
 		if err != nil {
 			_, _ = conn.ExecContext(ctx, "ROLLBACK")
@@ -142,6 +185,13 @@ func (s *SegmentationStorage) DeleteSegment(id string) (string, error) {
 			segmentFound = true
 		}
 
+		// Удаленный сегмент в выражениях производных сегментов дальше считается пустым
+		if err := s.refreshSegments(ctx, conn, dependents); err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+			return "", fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
 		prepareQuery := fmt.Sprintf("PREPARE TRANSACTION '%s'", txID)
 		if _, err := conn.ExecContext(ctx, prepareQuery); err != nil {
 			s.rollbackAll(txID, preparedShards)
@@ -166,14 +216,26 @@ func (s *SegmentationStorage) DeleteSegment(id string) (string, error) {
 /*
 	UpdateSegment - обновить записи о сегменте с таким id во всех шардах.
 
-Если хотя бы где-то существует сегмент - обновляем, иначе вернем ошибку
+Если хотя бы где-то существует сегмент - обновляем, иначе вернем ошибку.
+Незаполненные поля newSegment (пустое описание, nil-даты, nil-лимит) оставляют текущие значения, нулевой лимит участников его снимает.
+clearStartsAt и clearExpiresAt снимают время начала и окончания действия. Если после обновления сегмент
+истекает не позже начала действия, вернется ErrInvalidSchedule.
+Если задан newSegment.Id, сегмент переименовывается вместе со всеми записями users_segments (ON UPDATE CASCADE).
+Бакеты пользователей считаются по исходному id (bucket_key), поэтому переименование не меняет выборку.
+Каждому участнику в историю пишется запись о переименовании, прежняя история членства и ссылки в выражениях
+производных сегментов переносятся на новый id
 */
-func (s *SegmentationStorage) UpdateSegment(id string, newSegment models.Segment) (string, error) {
+func (s *SegmentationStorage) UpdateSegment(id string, newSegment models.Segment, clearStartsAt, clearExpiresAt bool) (string, error) {
 	ctx := context.Background()
 	txID := "tx_" + uuid.New().String()
 	preparedShards := make(map[int]bool)
 	segmentFound := false
 
+	newId := newSegment.Id
+	if newId == "" {
+		newId = id
+	}
+
 	for shardID, db := range s.dbShards {
 		conn, err := db.Conn(ctx)
 		if err != nil {
@@ -187,10 +249,47 @@ func (s *SegmentationStorage) UpdateSegment(id string, newSegment models.Segment
 			return "", fmt.Errorf("shard %d: begin failed: %w", shardID, err)
 		}
 
-		result, err := conn.ExecContext(ctx,
-			"UPDATE segments SET description = $1 WHERE id = $2",
-			newSegment.Description, id)
+		if newId != id {
+			if err := setChangeReason(ctx, conn, models.HistoryReasonSegmentRename); err != nil {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}
+
+		result, err := conn.ExecContext(ctx, `
+			UPDATE segments
+			SET description = COALESCE(NULLIF($1, ''), description),
+			    starts_at = CASE WHEN $9 THEN NULL ELSE COALESCE($2, starts_at) END,
+			    expires_at = CASE WHEN $10 THEN NULL ELSE COALESCE($3, expires_at) END,
+			    bucket_key = COALESCE(bucket_key, id),
+			    max_members = CASE WHEN $6::BIGINT IS NULL THEN max_members ELSE NULLIF($6, 0) END,
+			    payload = CASE WHEN $7::jsonb IS NULL THEN payload ELSE NULLIF($7::jsonb, 'null'::jsonb) END,
+			    payload_schema = CASE WHEN $8::jsonb IS NULL THEN payload_schema ELSE NULLIF($8::jsonb, 'null'::jsonb) END,
+			    id = $4
+			WHERE id = $5`,
+			newSegment.Description, newSegment.StartsAt, newSegment.ExpiresAt, newId, id, newSegment.MaxMembers,
+			nullableJSON(newSegment.Payload), nullableJSON(newSegment.PayloadSchema), clearStartsAt, clearExpiresAt)
 		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", apperrors.ErrSegmentAlreadyExists
+			}
+
+			if errors.As(err, &pqErr) && pqErr.Constraint == "segments_max_members_derived_check" {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", apperrors.ErrDerivedMemberCap
+			}
+
+			if errors.As(err, &pqErr) && pqErr.Constraint == "segments_schedule_check" {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", apperrors.ErrInvalidSchedule
+			}
+
 			_, _ = conn.ExecContext(ctx, "ROLLBACK")
 			s.rollbackAll(txID, preparedShards)
 			return "", fmt.Errorf("shard %d: update failed: %w", shardID, err)
@@ -207,6 +306,37 @@ func (s *SegmentationStorage) UpdateSegment(id string, newSegment models.Segment
 			segmentFound = true
 		}
 
+		if rowsAffected > 0 && newId != id {
+			err := renameInHistory(ctx, conn, id, newId)
+			if err == nil {
+				err = renameInExpressions(ctx, conn, id, newId)
+			}
+
+			if err != nil {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+				return "", fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}
+
+		if rowsAffected > 0 && (newSegment.Payload != nil || newSegment.PayloadSchema != nil || len(newSegment.Variants) > 0) {
+			err := updateVariantPayloads(ctx, conn, newId, newSegment.Variants)
+			if err == nil {
+				err = checkPayloads(ctx, conn, newId)
+			}
+
+			if err != nil {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK")
+				s.rollbackAll(txID, preparedShards)
+
+				if errors.Is(err, apperrors.ErrVariantNotFound) || errors.Is(err, apperrors.ErrPayloadMismatch) {
+					return "", err
+				}
+
+				return "", fmt.Errorf("shard %d: %w", shardID, err)
+			}
+		}
+
 		prepareQuery := fmt.Sprintf("PREPARE TRANSACTION '%s'", txID)
 		if _, err := conn.ExecContext(ctx, prepareQuery); err != nil {
 			s.rollbackAll(txID, preparedShards)
@@ -225,11 +355,15 @@ func (s *SegmentationStorage) UpdateSegment(id string, newSegment models.Segment
 		return "", fmt.Errorf("commit failed: %w", err)
 	}
 
-	return id, nil
+	return newId, nil
 }
 
-/* GetUserSegments - Получить данные о сегментах, в которых есть заданный пользователь
- */
+/*
+	GetUserSegments - Получить данные о сегментах, в которых есть заданный пользователь.
+
+Возвращает и выключенные, и еще не начавшиеся, и истекшие сегменты: их отсеивает сервис, чтобы закэшированный список не устаревал.
+По той же причине сюда попадают сегменты с переопределениями пользователя вместе с самими переопределениями, даже истекшими
+*/
 func (s *SegmentationStorage) GetUserSegments(id int) ([]models.Segment, error) {
 	ctx := context.Background()
 	shardNum := id % s.shardsNum
@@ -246,10 +380,13 @@ func (s *SegmentationStorage) GetUserSegments(id int) ([]models.Segment, error)
 	}
 
 	rows, err := db.QueryContext(ctx, `
-        SELECT seg.id, seg.description
-        FROM users_segments us
-        JOIN segments seg ON us.segment_id = seg.id
-        WHERE us.user_id = $1
+        SELECT seg.id, seg.description, seg.starts_at, seg.expires_at, seg.status, COALESCE(us.variant, ''),
+               COALESCE(o.action, ''), o.expires_at, us.segment_id IS NULL, COALESCE(v.payload, seg.payload)
+        FROM (SELECT segment_id, variant FROM users_segments WHERE user_id = $1) us
+        FULL JOIN (SELECT segment_id, action, expires_at FROM segment_overrides WHERE user_id = $1) o
+             ON o.segment_id = us.segment_id
+        JOIN segments seg ON seg.id = COALESCE(us.segment_id, o.segment_id)
+        LEFT JOIN segment_variants v ON v.segment_id = seg.id AND v.name = us.variant
     `, id)
 
 	if err != nil {
@@ -260,7 +397,8 @@ func (s *SegmentationStorage) GetUserSegments(id int) ([]models.Segment, error)
 	segments := []models.Segment{}
 	for rows.Next() {
 		var seg models.Segment
-		if err := rows.Scan(&seg.Id, &seg.Description); err != nil {
+		if err := rows.Scan(&seg.Id, &seg.Description, &seg.StartsAt, &seg.ExpiresAt, &seg.Status, &seg.Variant,
+			&seg.Override, &seg.OverrideExpiresAt, &seg.OverrideOnly, (*[]byte)(&seg.Payload)); err != nil {
 			return nil, fmt.Errorf("failed to scan segment: %w", err)
 		}
 		segments = append(segments, seg)
@@ -273,14 +411,72 @@ func (s *SegmentationStorage) GetUserSegments(id int) ([]models.Segment, error)
 }
 
 /*
-	GetUserSegments - Получить статистику сегмента по id.
+	GetUserMemberships - сегменты из segmentIds, в которых есть пользователь id или на которые у него есть переопределение.
 
-Ошибка только если нигде не нашли сегмент. Иначе информацию выведем
+Как и GetUserSegments, не отсеивает выключенные сегменты и истекшие переопределения. Читает только строки
+заданных сегментов по первичным ключам шарда пользователя, поэтому быстрее полного списка
+*/
+func (s *SegmentationStorage) GetUserMemberships(id int, segmentIds []string) ([]models.Segment, error) {
+	ctx := context.Background()
+	shardNum := id % s.shardsNum
+	db := s.dbShards[shardNum]
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT seg.id, seg.starts_at, seg.expires_at, COALESCE(seg.status, ''),
+		       COALESCE(o.action, ''), o.expires_at, us.segment_id IS NULL
+		FROM users u
+		LEFT JOIN ((SELECT segment_id FROM users_segments WHERE user_id = $1 AND segment_id = ANY($2)) us
+		           FULL JOIN (SELECT segment_id, action, expires_at FROM segment_overrides
+		                      WHERE user_id = $1 AND segment_id = ANY($2)) o
+		                ON o.segment_id = us.segment_id)
+		     ON TRUE
+		LEFT JOIN segments seg ON seg.id = COALESCE(us.segment_id, o.segment_id)
+		WHERE u.id = $1
+	`, id, pq.Array(segmentIds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user memberships: %w", err)
+	}
+	defer rows.Close()
+
+	found := false
+	segments := []models.Segment{}
+	for rows.Next() {
+		var segId sql.NullString
+		var seg models.Segment
+
+		if err := rows.Scan(&segId, &seg.StartsAt, &seg.ExpiresAt, &seg.Status,
+			&seg.Override, &seg.OverrideExpiresAt, &seg.OverrideOnly); err != nil {
+			return nil, fmt.Errorf("failed to scan membership: %w", err)
+		}
+
+		found = true
+		if segId.Valid {
+			seg.Id = segId.String
+			segments = append(segments, seg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	if !found {
+		return nil, apperrors.ErrUserNotFound
+	}
+
+	return segments, nil
+}
+
+/*
+	GetSegmentInfo - Получить статистику сегмента по id.
+
+Ошибка только если нигде не нашли сегмент. Иначе информацию выведем вместе с числом участников на каждом шарде.
+Шарды, которые не ответили, перечисляются в FailedShards: без них числа участников занижены
 */
 func (s *SegmentationStorage) GetSegmentInfo(id string) (models.SegmentInfo, error) {
 	type result struct {
-		info models.SegmentInfo
-		err  error
+		shardID int
+		info    models.SegmentInfo
+		err     error
 	}
 
 	ctx := context.Background()
@@ -299,26 +495,47 @@ func (s *SegmentationStorage) GetSegmentInfo(id string) (models.SegmentInfo, err
 					SELECT COUNT(*) as users_count FROM users_segments WHERE segment_id = $1
 				),
 				info AS (
-					SELECT id, description FROM segments WHERE id = $1
+					SELECT id, description, starts_at, expires_at, salt, target_buckets, rule, auto_enroll, status,
+					       COALESCE(layer_id, '') AS layer_id, COALESCE(derived_expr, '') AS derived_expr,
+					       COALESCE(derived_mode, '') AS derived_mode, target_count, max_members, payload, payload_schema
+					FROM segments WHERE id = $1
 				)
-				SELECT info.id, info.description, cnt.users_count
+				SELECT info.id, info.description, info.starts_at, info.expires_at, info.salt, info.target_buckets, info.rule,
+				       info.auto_enroll, info.status, info.layer_id, info.derived_expr, info.derived_mode, info.target_count,
+				       info.max_members, info.payload, info.payload_schema, cnt.users_count
 				FROM cnt JOIN info ON TRUE;
 			`
 
 			row := db.QueryRowContext(ctx, query, id)
 
 			var si models.SegmentInfo
-			err := row.Scan(&si.Id, &si.Description, &si.UsersNum)
+			err := row.Scan(&si.Id, &si.Description, &si.StartsAt, &si.ExpiresAt, &si.Salt, &si.TargetBuckets, &si.Rule, &si.AutoEnroll, &si.Status, &si.LayerId,
+				&si.DerivedExpr, &si.DerivedMode, &si.TargetCount, &si.MaxMembers, (*[]byte)(&si.Payload), (*[]byte)(&si.PayloadSchema),
+				&si.UsersNum)
 			if err != nil {
 				if errors.Is(err, sql.ErrNoRows) {
-					resultCh <- result{err: apperrors.ErrSegmentNotFound}
+					resultCh <- result{shardID: shardID, err: apperrors.ErrSegmentNotFound}
+					return
+				}
+				resultCh <- result{shardID: shardID, err: fmt.Errorf("shard %d: %w", shardID, err)}
+				return
+			}
+
+			if si.LayerId != "" {
+				si.LayerFreeUsers, si.LayerTotalUsers, err = layerUsage(ctx, db, si.LayerId)
+				if err != nil {
+					resultCh <- result{shardID: shardID, err: fmt.Errorf("shard %d: failed to count layer usage: %w", shardID, err)}
 					return
 				}
-				resultCh <- result{err: fmt.Errorf("shard %d: %w", shardID, err)}
+			}
+
+			si.Variants, err = variantsInfo(ctx, db, id)
+			if err != nil {
+				resultCh <- result{shardID: shardID, err: fmt.Errorf("shard %d: failed to count variant users: %w", shardID, err)}
 				return
 			}
 
-			resultCh <- result{info: si}
+			resultCh <- result{shardID: shardID, info: si}
 		}(shardID, db)
 	}
 
@@ -327,22 +544,41 @@ func (s *SegmentationStorage) GetSegmentInfo(id string) (models.SegmentInfo, err
 		close(resultCh)
 	}()
 
-	cumResult := models.SegmentInfo{}
+	cumResult := models.SegmentInfo{Shards: []models.ShardUsers{}, FailedShards: []int{}}
 	found := false
 	errorsFound := []error{}
 
 	for res := range resultCh {
 		if res.err != nil {
 			errorsFound = append(errorsFound, res.err)
+			cumResult.FailedShards = append(cumResult.FailedShards, res.shardID)
 			continue
 		}
 		if res.info.Id != "" {
 			if !found {
 				cumResult.Id = res.info.Id
 				cumResult.Description = res.info.Description
+				cumResult.StartsAt = res.info.StartsAt
+				cumResult.ExpiresAt = res.info.ExpiresAt
+				cumResult.Salt = res.info.Salt
+				cumResult.TargetBuckets = res.info.TargetBuckets
+				cumResult.Rule = res.info.Rule
+				cumResult.AutoEnroll = res.info.AutoEnroll
+				cumResult.Status = res.info.Status
+				cumResult.LayerId = res.info.LayerId
+				cumResult.DerivedExpr = res.info.DerivedExpr
+				cumResult.DerivedMode = res.info.DerivedMode
+				cumResult.TargetCount = res.info.TargetCount
+				cumResult.MaxMembers = res.info.MaxMembers
+				cumResult.Payload = res.info.Payload
+				cumResult.PayloadSchema = res.info.PayloadSchema
 				found = true
 			}
 			cumResult.UsersNum += res.info.UsersNum
+			cumResult.Shards = append(cumResult.Shards, models.ShardUsers{ShardId: res.shardID, UsersNum: res.info.UsersNum})
+			cumResult.LayerFreeUsers += res.info.LayerFreeUsers
+			cumResult.LayerTotalUsers += res.info.LayerTotalUsers
+			cumResult.Variants = mergeVariantsInfo(cumResult.Variants, res.info.Variants)
 		}
 	}
 
@@ -351,90 +587,336 @@ func (s *SegmentationStorage) GetSegmentInfo(id string) (models.SegmentInfo, err
 		return models.SegmentInfo{}, apperrors.ErrSegmentNotFound
 	}
 
+	if len(errorsFound) > 0 {
+		s.log.Warn(fmt.Sprintf("segment info is partial. Errors: %v", errorsFound), slog.String("id", id))
+	}
+
+	sort.Slice(cumResult.Shards, func(i, j int) bool { return cumResult.Shards[i].ShardId < cumResult.Shards[j].ShardId })
+	sort.Ints(cumResult.FailedShards)
+
 	return cumResult, nil
 }
 
-/* DistributeSegment - распространить сегмент на пользователей, если только среди новых пользователей нет уже добавленных записей
- */
-func (s *SegmentationStorage) DistributeSegment(id string, usersPercentage int) (string, error) {
+/*
+	GetExpiredSegments - получить id сегментов, срок действия которых уже истек.
+
+Сегменты копируются на все шарды, поэтому собираем id со всех шардов, чтобы не пропустить
+сегмент, который по какой-то причине остался только на части из них
+*/
+func (s *SegmentationStorage) GetExpiredSegments() ([]string, error) {
 	ctx := context.Background()
-	txID := "tx_" + uuid.New().String()
-	preparedShards := make(map[int]bool)
+	found := make(map[string]bool)
+	ids := make([]string, 0)
+
+	for shardID, db := range s.dbShards {
+		rows, err := db.QueryContext(ctx, "SELECT id FROM segments WHERE expires_at <= now()")
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: failed to query expired segments: %w", shardID, err)
+		}
+
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("shard %d: failed to scan segment id: %w", shardID, err)
+			}
+
+			if !found[id] {
+				found[id] = true
+				ids = append(ids, id)
+			}
+		}
 
-	percentage := float64(usersPercentage)
-	// Добавим 5 процентов, чтобы уменьшить вероятность выборки меньшего числа пользователей
-	upperPercentage := percentage + 5
-	if upperPercentage > 100 {
-		upperPercentage = 100
+		err = rows.Err()
+		rows.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: rows error: %w", shardID, err)
+		}
 	}
 
-	for shardID, db := range s.dbShards {
-		conn, err := db.Conn(ctx)
+	return ids, nil
+}
+
+/*
+	DistributeSegment - распространить сегмент на заданную долю или число пользователей, подходящих под rule.
+
+Цель и условие сохраняются в сегменте. При распространении по проценту пользователь входит в целевую выборку,
+если его бакет по хэшу (id сегмента, соль, id пользователя) меньше порога. Поэтому при увеличении процента
+добавляются только новые пользователи, а при уменьшении удаляются те, чей бакет оказался за порогом.
+При распространении по количеству число делится между шардами пропорционально числу подходящих пользователей
+(по условию, без контрольной группы и занятых в слое), и на каждом шарде выбираются подходящие пользователи с наименьшими бакетами - выборка так же устойчива.
+Пользователи, уже занятые другим сегментом того же слоя, не выбираются. Вручную добавленных пользователей распространение не трогает.
+При autoEnroll новые пользователи будут добавляться в сегмент при создании по тем же процентам и условию.
+Если у сегмента есть лимит участников, свободные места делятся между шардами пропорционально числу новых кандидатов,
+и на каждом шарде добавляются кандидаты с наименьшими бакетами, остальные учитываются как отклоненные.
+Архивный сегмент распространять нельзя
+*/
+func (s *SegmentationStorage) DistributeSegment(id string, target models.DistributionTarget, rule *rules.Rule, autoEnroll bool) (models.DistributionResult, error) {
+	res := models.DistributionResult{Id: id, Shards: []models.ShardDistribution{}}
+
+	threshold := bucketing.PercentageToBuckets(target.Percentage)
+	var targetCount *int64
+	var quotas map[int]int64
+	// allowed - сколько пользователей можно добавить на каждом шарде из-за лимита участников, nil - без лимита
+	var allowed map[int]int64
+	capShard := s.capacityShard()
+
+	if target.ByCount {
+		threshold = 0
+		targetCount = &target.Count
+	}
+
+	err := s.inTwoPhaseTx(s.allShards(), func(shardID int, conn *sql.Conn) error {
+		ctx := context.Background()
+
+		status, err := lockSegment(ctx, conn, shardID, id)
 		if err != nil {
-			s.rollbackAll(txID, preparedShards)
-			return "", fmt.Errorf("shard %d: failed to get DB connection: %w", shardID, err)
+			return err
 		}
 
-		defer conn.Close()
+		if status == models.SegmentStatusArchived {
+			return apperrors.ErrSegmentArchived
+		}
 
-		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
-			s.rollbackAll(txID, preparedShards)
-			return "", fmt.Errorf("shard %d: begin failed: %w", shardID, err)
+		if shardID == capShard {
+			// Пока сегмент заблокирован в первом шарде, его не распространяют параллельно,
+			// поэтому доли шардов считаются по подходящим пользователям на момент распространения
+			if target.ByCount {
+				quotas, err = s.shardQuotas(ctx, id, rule, target.Count)
+				if err != nil {
+					return err
+				}
+			}
+
+			maxMembers, err := segmentMaxMembers(ctx, conn, id)
+			if err != nil {
+				return fmt.Errorf("shard %d: %w", shardID, err)
+			}
+
+			if maxMembers.Valid {
+				previews, _, err := s.previewShards(ctx, id, target, rule, quotas, 0)
+				if err != nil {
+					return err
+				}
+
+				allowed = capacityQuotas(maxMembers.Int64, previews)
+			}
 		}
 
-		query := `
-			WITH
-			target_limit AS (
-				SELECT COUNT(*) * $2 / 100 AS max_count FROM users
-			),
-			users_to_add AS (
-				SELECT id FROM users TABLESAMPLE BERNOULLI($3)
-			),
-			users_to_add_limited AS (
-				SELECT id FROM users_to_add LIMIT (SELECT max_count FROM target_limit)
-			),
-			seg AS (
-				SELECT id FROM segments WHERE id = $1
-			),
-			new_vals AS (
-				SELECT users_to_add_limited.id as user_id, seg.id as segment_id FROM users_to_add_limited JOIN seg ON TRUE
-			)
-			INSERT INTO users_segments (user_id, segment_id)
-			SELECT user_id, segment_id FROM new_vals;
-		`
-
-		_, err = conn.ExecContext(ctx, query, id, percentage, upperPercentage)
+		result, err := conn.ExecContext(ctx,
+			"UPDATE segments SET target_buckets = $1, target_count = $2, rule = $3, auto_enroll = $4 WHERE id = $5",
+			threshold, targetCount, rule.String(), autoEnroll, id)
 		if err != nil {
-			var pqErr *pq.Error
-			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
-				_, _ = conn.ExecContext(ctx, "ROLLBACK")
-				s.rollbackAll(txID, preparedShards)
-				return "", apperrors.ErrSegmentDistributed
+			return fmt.Errorf("shard %d: update failed: %w", shardID, err)
+		}
+
+		if err := setChangeReason(ctx, conn, models.HistoryReasonDistribution); err != nil {
+			return fmt.Errorf("shard %d: %w", shardID, err)
+		}
+
+		inTarget, args := s.distributionSelection(id, target, rule, quotas[shardID])
+		insertTarget, insertArgs := inTarget, args
+
+		// При лимите участников добавляются только allowed новых кандидатов с наименьшими бакетами
+		if allowed != nil {
+			insertArgs = append(args[:len(args):len(args)], allowed[shardID])
+			insertTarget = fmt.Sprintf(`u.id IN (
+				SELECT u.id FROM users u JOIN segments seg ON seg.id = $1
+				WHERE %s AND NOT EXISTS (SELECT 1 FROM users_segments us WHERE us.user_id = u.id AND us.segment_id = seg.id)
+				ORDER BY %s, u.id
+				LIMIT $%d
+			)`, inTarget, bucketing.SQL("COALESCE(seg.bucket_key, seg.id)", "seg.salt", "u.id"), len(insertArgs))
+		}
+
+		result, err = conn.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO users_segments (user_id, segment_id, source)
+			SELECT u.id, seg.id, $2
+			FROM users u
+			JOIN segments seg ON seg.id = $1
+			WHERE %s
+			ON CONFLICT DO NOTHING
+		`, insertTarget), insertArgs...)
+		if err != nil {
+			return fmt.Errorf("shard %d: insert failed: %w", shardID, err)
+		}
+
+		added, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to get affected rows: %w", shardID, err)
+		}
+
+		result, err = conn.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM users_segments us
+			USING users u, segments seg
+			WHERE us.segment_id = $1 AND us.source = $2
+			  AND u.id = us.user_id AND seg.id = us.segment_id
+			  AND NOT %s
+		`, inTarget), args...)
+		if err != nil {
+			return fmt.Errorf("shard %d: delete failed: %w", shardID, err)
+		}
+
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to get affected rows: %w", shardID, err)
+		}
+
+		var rejected int64
+		if allowed != nil {
+			err = conn.QueryRowContext(ctx, fmt.Sprintf(`
+				SELECT COUNT(*) FROM users u JOIN segments seg ON seg.id = $1
+				WHERE %s AND NOT EXISTS (SELECT 1 FROM users_segments us WHERE us.user_id = u.id AND us.segment_id = seg.id)
+			`, inTarget), args...).Scan(&rejected)
+			if err != nil {
+				return fmt.Errorf("shard %d: failed to count rejected users: %w", shardID, err)
 			}
+		}
 
-			_, _ = conn.ExecContext(ctx, "ROLLBACK")
-			s.rollbackAll(txID, preparedShards)
-			return "", fmt.Errorf("shard %d: insert failed: %w", shardID, err)
+		var assigned int64
+		err = conn.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM users_segments WHERE segment_id = $1 AND source = $2",
+			id, models.MemberSourceDistribution).Scan(&assigned)
+		if err != nil {
+			return fmt.Errorf("shard %d: failed to count assigned users: %w", shardID, err)
 		}
 
-		prepareQuery := fmt.Sprintf("PREPARE TRANSACTION '%s'", txID)
-		if _, err := conn.ExecContext(ctx, prepareQuery); err != nil {
-			s.rollbackAll(txID, preparedShards)
-			return "", fmt.Errorf("shard %d: prepare failed: %w", shardID, err)
+		if added > 0 || removed > 0 {
+			if err := s.refreshDerived(ctx, conn, []string{id}); err != nil {
+				return fmt.Errorf("shard %d: %w", shardID, err)
+			}
 		}
 
-		preparedShards[shardID] = true
+		res.Shards = append(res.Shards, models.ShardDistribution{
+			ShardId:   shardID,
+			Added:     added,
+			Removed:   removed,
+			Requested: quotas[shardID],
+			Assigned:  assigned,
+			Rejected:  rejected,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return models.DistributionResult{}, err
 	}
 
-	if err := s.commitAll(txID, preparedShards); err != nil {
-		return "", fmt.Errorf("commit failed: %w", err)
+	return res, nil
+}
+
+/*
+	distributionSelection - условие SQL, что пользователь u входит в целевую выборку сегмента seg при распространении на target.
+
+Параметры $1 и $2 - id сегмента и источник членства distribution, за ними идут параметры условия.
+quota - число пользователей шарда при распространении по количеству
+*/
+func (s *SegmentationStorage) distributionSelection(id string, target models.DistributionTarget, rule *rules.Rule, quota int64) (string, []any) {
+	args := []any{id, models.MemberSourceDistribution}
+	bucket := bucketing.SQL("COALESCE(seg.bucket_key, seg.id)", "seg.salt", "u.id")
+
+	if !target.ByCount {
+		args = append(args, bucketing.PercentageToBuckets(target.Percentage))
+		threshold := fmt.Sprintf("$%d", len(args))
+
+		eligible, args := s.distributionEligibleSQL(rule, args)
+
+		return fmt.Sprintf("(%s < %s AND %s)", bucket, threshold, eligible), args
 	}
 
-	return id, nil
+	args = append(args, quota)
+	limit := fmt.Sprintf("$%d", len(args))
+
+	eligible, args := s.distributionEligibleSQL(rule, args)
+
+	return fmt.Sprintf(`u.id IN (
+		SELECT u.id FROM users u JOIN segments seg ON seg.id = $1
+		WHERE %s
+		ORDER BY %s, u.id
+		LIMIT %s
+	)`, eligible, bucket, limit), args
 }
 
 /*
-CreateUser - создать пользователя в нужном шарде
+	distributionEligibleSQL - условие SQL, что пользователя u можно выбрать в сегмент seg независимо от его бакета.
+
+Пользователи глобальной контрольной группы и занятые другим сегментом слоя не подходят. Параметры условия дописываются к args
+*/
+func (s *SegmentationStorage) distributionEligibleSQL(rule *rules.Rule, args []any) (string, []any) {
+	cond, args := rule.SQL("u.attributes", args)
+	inHoldout, args := s.holdout.SQL("u.id", args)
+
+	return fmt.Sprintf("(NOT %s AND %s AND %s)", inHoldout, layerFreeSQL(), cond), args
+}
+
+/*
+	shardQuotas - разделить total пользователей между шардами пропорционально числу пользователей,
+	которых можно выбрать в сегмент id по условию rule (без контрольной группы и занятых другим сегментом слоя).
+
+Доли округляются вниз, а остаток раздается шардам с наибольшими дробными частями, поэтому сумма равна total.
+Доля шарда не превышает числа его подходящих пользователей. Если подходящих пользователей меньше total,
+каждый шард получает их всех, и недостача видна по числу назначенных пользователей в результате
+*/
+func (s *SegmentationStorage) shardQuotas(ctx context.Context, id string, rule *rules.Rule, total int64) (map[int]int64, error) {
+	eligible, args := s.distributionEligibleSQL(rule, []any{id})
+	query := fmt.Sprintf("SELECT COUNT(*) FROM users u JOIN segments seg ON seg.id = $1 WHERE %s", eligible)
+
+	counts := make(map[int]int64, len(s.dbShards))
+
+	var allEligible int64
+	for shardID, db := range s.dbShards {
+		var cnt int64
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&cnt); err != nil {
+			return nil, fmt.Errorf("shard %d: failed to count eligible users: %w", shardID, err)
+		}
+
+		counts[shardID] = cnt
+		allEligible += cnt
+	}
+
+	if total >= allEligible {
+		return counts, nil
+	}
+
+	return splitProportionally(total, counts, allEligible), nil
+}
+
+// splitProportionally - разделить total пропорционально weights методом наибольших остатков. total и веса - не больше MaxInt32
+func splitProportionally(total int64, weights map[int]int64, weightsSum int64) map[int]int64 {
+	res := make(map[int]int64, len(weights))
+	if weightsSum == 0 {
+		return res
+	}
+
+	keys := make([]int, 0, len(weights))
+	remainders := make(map[int]int64, len(weights))
+	var given int64
+
+	for key, w := range weights {
+		res[key] = total * w / weightsSum
+		remainders[key] = total * w % weightsSum
+		given += res[key]
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if remainders[keys[i]] != remainders[keys[j]] {
+			return remainders[keys[i]] > remainders[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	for i := 0; given < total; i++ {
+		res[keys[i%len(keys)]]++
+		given++
+	}
+
+	return res
+}
+
+/*
+CreateUser - создать пользователя в нужном шарде и сразу добавить его в сегменты с автодобавлением
 */
 func (s *SegmentationStorage) CreateUser(user models.User) (int, error) {
 	ctx := context.Background()
@@ -448,7 +930,16 @@ func (s *SegmentationStorage) CreateUser(user models.User) (int, error) {
 
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO users (id) VALUES ($1)", user.Id)
+	if user.Attributes == nil {
+		user.Attributes = map[string]any{}
+	}
+
+	attributes, err := json.Marshal(user.Attributes)
+	if err != nil {
+		return -1, fmt.Errorf("failed to encode attributes: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (id, attributes) VALUES ($1, $2)", user.Id, string(attributes))
 	if err != nil {
 		var pqErr *pq.Error
 		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
@@ -457,6 +948,19 @@ func (s *SegmentationStorage) CreateUser(user models.User) (int, error) {
 		return -1, fmt.Errorf("insert failed: %w", err)
 	}
 
+	// Атрибуты проверяем в том же виде, в каком они лежат в jsonb, чтобы условия совпадали с распространением
+	var attrs map[string]any
+	if err := json.Unmarshal(attributes, &attrs); err != nil {
+		return -1, fmt.Errorf("failed to decode attributes: %w", err)
+	}
+
+	release, err := s.autoEnroll(ctx, tx, user.Id, attrs)
+	defer release()
+
+	if err != nil {
+		return -1, err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return -1, fmt.Errorf("commit failed: %w", err)
 	}
@@ -479,6 +983,10 @@ func (s *SegmentationStorage) DeleteUser(id int) (int, error) {
 
 	defer tx.Rollback()
 
+	if err := setChangeReason(ctx, tx, models.HistoryReasonUserDeletion); err != nil {
+		return -1, err
+	}
+
 	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
 	if err != nil {
 		return -1, fmt.Errorf("delete failed: %w", err)
@@ -515,14 +1023,25 @@ func (s *SegmentationStorage) rollbackAll(txID string, preparedShards map[int]bo
 	}
 }
 
-// commitAll - пробуем закоммитить подготовленные транзакции во всех шардах. Может и не получиться. Но это маловероятно.
+/*
+	commitAll - пробуем закоммитить подготовленные транзакции во всех шардах. Может и не получиться. Но это маловероятно.
+
+Шарды коммитятся по убыванию id: блокировки шарда capacityShard снимаются последними,
+и следующая транзакция под ними уже видит изменения на всех шардах
+*/
 func (s *SegmentationStorage) commitAll(txID string, preparedShards map[int]bool) error {
 	var firstErr error
 
+	shardIDs := make([]int, 0, len(preparedShards))
 	for shardID, prepared := range preparedShards {
-		if !prepared {
-			continue
+		if prepared {
+			shardIDs = append(shardIDs, shardID)
 		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(shardIDs)))
+
+	for _, shardID := range shardIDs {
 
 		db := s.dbShards[shardID]
 		query := fmt.Sprintf("COMMIT PREPARED '%s'", txID)
@@ -0,0 +1,200 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/internal/domain/models"
+	"main/internal/domain/rules"
+	apperrors "main/internal/errors"
+	"sort"
+	"sync"
+)
+
+// shardPreview - изменения состава сегмента на одном шарде при распространении без учета лимита участников
+type shardPreview struct {
+	added    int64 // целевые пользователи, еще не состоящие в сегменте
+	removed  int64 // участники по распространению вне целевой выборки
+	assigned int64 // участники по распространению до него
+	members  int64 // все участники сегмента до распространения
+	sample   []int
+}
+
+// distribution - изменения на шарде shardID, если добавить на нем не больше allowed пользователей
+func (p shardPreview) distribution(shardID int, requested, allowed int64) models.ShardDistribution {
+	added := min(p.added, allowed)
+
+	return models.ShardDistribution{
+		ShardId:   shardID,
+		Added:     added,
+		Removed:   p.removed,
+		Requested: requested,
+		Assigned:  p.assigned - p.removed + added,
+		Rejected:  p.added - added,
+	}
+}
+
+/*
+	PreviewDistribution - посчитать, что сделал бы DistributeSegment с теми же аргументами, ничего не меняя.
+
+На каждом шарде выполняется та же выборка, что и при распространении, но только на чтение.
+Учитывается и лимит участников сегмента. Возвращает изменения по шардам
+и до sampleSize id пользователей из целевой выборки по возрастанию
+*/
+func (s *SegmentationStorage) PreviewDistribution(id string, target models.DistributionTarget, rule *rules.Rule, sampleSize int) (models.DistributionPreview, error) {
+	var quotas map[int]int64
+	if target.ByCount {
+		var err error
+		quotas, err = s.shardQuotas(context.Background(), id, rule, target.Count)
+		if err != nil {
+			return models.DistributionPreview{}, err
+		}
+	}
+
+	previews, maxMembers, err := s.previewShards(context.Background(), id, target, rule, quotas, sampleSize)
+	if err != nil {
+		return models.DistributionPreview{}, err
+	}
+
+	var allowed map[int]int64
+	if maxMembers.Valid {
+		allowed = capacityQuotas(maxMembers.Int64, previews)
+	}
+
+	res := models.DistributionPreview{
+		DistributionResult: models.DistributionResult{Id: id, Shards: []models.ShardDistribution{}},
+		SampleUserIds:      []int{},
+	}
+
+	for shardID, p := range previews {
+		limit := p.added
+		if allowed != nil {
+			limit = allowed[shardID]
+		}
+
+		res.Shards = append(res.Shards, p.distribution(shardID, quotas[shardID], limit))
+		res.SampleUserIds = append(res.SampleUserIds, p.sample...)
+	}
+
+	sort.Slice(res.Shards, func(i, j int) bool { return res.Shards[i].ShardId < res.Shards[j].ShardId })
+	sort.Ints(res.SampleUserIds)
+
+	if len(res.SampleUserIds) > sampleSize {
+		res.SampleUserIds = res.SampleUserIds[:sampleSize]
+	}
+
+	return res, nil
+}
+
+/*
+	previewShards - посчитать изменения распространения параллельно на всех шардах.
+
+Возвращает также лимит участников сегмента. При sampleSize = 0 примеры пользователей не выбираются
+*/
+func (s *SegmentationStorage) previewShards(ctx context.Context, id string, target models.DistributionTarget, rule *rules.Rule,
+	quotas map[int]int64, sampleSize int) (map[int]shardPreview, sql.NullInt64, error) {
+	type result struct {
+		shardID    int
+		preview    shardPreview
+		maxMembers sql.NullInt64
+		err        error
+	}
+
+	resultCh := make(chan result, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			preview, maxMembers, err := s.previewShard(ctx, db, id, target, rule, quotas[shardID], sampleSize)
+			if err != nil {
+				if !errors.Is(err, apperrors.ErrSegmentNotFound) && !errors.Is(err, apperrors.ErrSegmentArchived) {
+					err = fmt.Errorf("shard %d: %w", shardID, err)
+				}
+				resultCh <- result{err: err}
+				return
+			}
+
+			resultCh <- result{shardID: shardID, preview: preview, maxMembers: maxMembers}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	previews := make(map[int]shardPreview, len(s.dbShards))
+	var maxMembers sql.NullInt64
+
+	for r := range resultCh {
+		if r.err != nil {
+			return nil, sql.NullInt64{}, r.err
+		}
+
+		previews[r.shardID] = r.preview
+		maxMembers = r.maxMembers
+	}
+
+	return previews, maxMembers, nil
+}
+
+// previewShard - изменения состава сегмента на одном шарде, его лимит участников и первые sampleSize целевых пользователей
+func (s *SegmentationStorage) previewShard(ctx context.Context, db *sql.DB, id string, target models.DistributionTarget,
+	rule *rules.Rule, quota int64, sampleSize int) (shardPreview, sql.NullInt64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return shardPreview{}, sql.NullInt64{}, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var status string
+	var maxMembers sql.NullInt64
+
+	err = conn.QueryRowContext(ctx, "SELECT status, max_members FROM segments WHERE id = $1", id).Scan(&status, &maxMembers)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shardPreview{}, sql.NullInt64{}, apperrors.ErrSegmentNotFound
+		}
+		return shardPreview{}, sql.NullInt64{}, fmt.Errorf("failed to read segment status: %w", err)
+	}
+
+	if status == models.SegmentStatusArchived {
+		return shardPreview{}, sql.NullInt64{}, apperrors.ErrSegmentArchived
+	}
+
+	inTarget, args := s.distributionSelection(id, target, rule, quota)
+
+	var p shardPreview
+	err = conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT
+			(SELECT COUNT(*) FROM users u JOIN segments seg ON seg.id = $1
+			 WHERE %[1]s AND NOT EXISTS (SELECT 1 FROM users_segments us WHERE us.user_id = u.id AND us.segment_id = seg.id)),
+			(SELECT COUNT(*) FROM users_segments us JOIN users u ON u.id = us.user_id JOIN segments seg ON seg.id = us.segment_id
+			 WHERE us.segment_id = $1 AND us.source = $2 AND NOT %[1]s),
+			(SELECT COUNT(*) FROM users_segments WHERE segment_id = $1 AND source = $2),
+			(SELECT COUNT(*) FROM users_segments WHERE segment_id = $1)
+	`, inTarget), args...).Scan(&p.added, &p.removed, &p.assigned, &p.members)
+	if err != nil {
+		return shardPreview{}, sql.NullInt64{}, fmt.Errorf("failed to count distribution changes: %w", err)
+	}
+
+	if sampleSize == 0 {
+		return p, maxMembers, nil
+	}
+
+	args = append(args, sampleSize)
+	p.sample, err = queryUserIds(ctx, conn, fmt.Sprintf(`
+		SELECT u.id FROM users u JOIN segments seg ON seg.id = $1
+		WHERE %s
+		ORDER BY u.id
+		LIMIT $%d
+	`, inTarget, len(args)), args...)
+	if err != nil {
+		return shardPreview{}, sql.NullInt64{}, fmt.Errorf("failed to query sample users: %w", err)
+	}
+
+	return p, maxMembers, nil
+}
@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	SnapshotSegmentStats - записать на каждом шарде снимок числа участников всех сегментов за текущий день (UTC).
+
+Повторный снимок за тот же день перезаписывает предыдущий. Шарды снимаются независимо:
+ошибка на одном не мешает остальным, все ошибки возвращаются вместе
+*/
+func (s *SegmentationStorage) SnapshotSegmentStats() error {
+	ctx := context.Background()
+	errCh := make(chan error, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			_, err := db.ExecContext(ctx, `
+				INSERT INTO segment_stats (segment_id, day, users_num)
+				SELECT seg.id, (now() AT TIME ZONE 'UTC')::date,
+				       (SELECT COUNT(*) FROM users_segments us WHERE us.segment_id = seg.id)
+				FROM segments seg
+				ON CONFLICT (segment_id, day) DO UPDATE SET users_num = EXCLUDED.users_num, taken_at = now()
+			`)
+			if err != nil {
+				errCh <- fmt.Errorf("shard %d: failed to snapshot segment stats: %w", shardID, err)
+			}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	errs := make([]error, 0)
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+	GetSegmentStatsHistory - ежедневные снимки числа участников сегмента id за дни с from по to включительно,
+	сложенные по шардам.
+
+Нулевые from и to не ограничивают период. Шарды, которые не ответили, перечисляются в FailedShards.
+Ошибка, только если сегмент не нашелся ни на одном шарде
+*/
+func (s *SegmentationStorage) GetSegmentStatsHistory(id string, from, to time.Time) (models.StatsHistory, error) {
+	type result struct {
+		shardID int
+		points  []models.StatsPoint
+		err     error
+	}
+
+	var fromArg, toArg any
+	if !from.IsZero() {
+		fromArg = from.UTC().Format(time.DateOnly)
+	}
+	if !to.IsZero() {
+		toArg = to.UTC().Format(time.DateOnly)
+	}
+
+	ctx := context.Background()
+	resultCh := make(chan result, len(s.dbShards))
+	wg := sync.WaitGroup{}
+
+	for shardID, db := range s.dbShards {
+		wg.Add(1)
+
+		go func(shardID int, db *sql.DB) {
+			defer wg.Done()
+
+			points, err := shardStatsHistory(ctx, db, id, fromArg, toArg)
+			if err != nil && !errors.Is(err, apperrors.ErrSegmentNotFound) {
+				err = fmt.Errorf("shard %d: %w", shardID, err)
+			}
+
+			resultCh <- result{shardID: shardID, points: points, err: err}
+		}(shardID, db)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	res := models.StatsHistory{Id: id, Points: []models.StatsPoint{}, FailedShards: []int{}}
+	byDay := make(map[time.Time]*models.StatsPoint)
+	found := false
+	errorsFound := []error{}
+
+	for r := range resultCh {
+		if r.err != nil {
+			errorsFound = append(errorsFound, r.err)
+			res.FailedShards = append(res.FailedShards, r.shardID)
+			continue
+		}
+
+		found = true
+
+		for _, p := range r.points {
+			if point, ok := byDay[p.Day]; ok {
+				point.UsersNum += p.UsersNum
+				point.Shards++
+				continue
+			}
+
+			byDay[p.Day] = &models.StatsPoint{Day: p.Day, UsersNum: p.UsersNum, Shards: 1}
+		}
+	}
+
+	if !found {
+		s.log.Error(fmt.Sprintf("failed to read segment stats history. Errors: %v", errorsFound), slog.String("id", id))
+		return models.StatsHistory{}, apperrors.ErrSegmentNotFound
+	}
+
+	if len(errorsFound) > 0 {
+		s.log.Warn(fmt.Sprintf("segment stats history is partial. Errors: %v", errorsFound), slog.String("id", id))
+	}
+
+	for _, point := range byDay {
+		res.Points = append(res.Points, *point)
+	}
+
+	sort.Slice(res.Points, func(i, j int) bool { return res.Points[i].Day.Before(res.Points[j].Day) })
+	sort.Ints(res.FailedShards)
+
+	return res, nil
+}
+
+// shardStatsHistory - снимки числа участников сегмента id на одном шарде по возрастанию дня
+func shardStatsHistory(ctx context.Context, db *sql.DB, id string, from, to any) ([]models.StatsPoint, error) {
+	var exists bool
+
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM segments WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check segment existence: %w", err)
+	}
+
+	if !exists {
+		return nil, apperrors.ErrSegmentNotFound
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT day, users_num
+		FROM segment_stats
+		WHERE segment_id = $1
+		  AND ($2::date IS NULL OR day >= $2)
+		  AND ($3::date IS NULL OR day <= $3)
+		ORDER BY day
+	`, id, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query segment stats: %w", err)
+	}
+	defer rows.Close()
+
+	points := []models.StatsPoint{}
+	for rows.Next() {
+		var p models.StatsPoint
+		if err := rows.Scan(&p.Day, &p.UsersNum); err != nil {
+			return nil, fmt.Errorf("failed to scan segment stats: %w", err)
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return points, nil
+}
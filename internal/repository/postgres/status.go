@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+/*
+	SetSegmentStatus - сменить статус сегмента id на всех шардах.
+
+Участники сегмента при этом не меняются: выключенный сегмент просто перестает выдаваться пользователям.
+Вернуть в черновик уже запущенный сегмент нельзя
+*/
+func (s *SegmentationStorage) SetSegmentStatus(id string, status string) (string, error) {
+	err := s.inTwoPhaseTx(s.allShards(), func(shardID int, conn *sql.Conn) error {
+		ctx := context.Background()
+
+		current, err := lockSegment(ctx, conn, shardID, id)
+		if err != nil {
+			return err
+		}
+
+		if status == models.SegmentStatusDraft && current != models.SegmentStatusDraft {
+			return apperrors.ErrInvalidStatusChange
+		}
+
+		if _, err := conn.ExecContext(ctx, "UPDATE segments SET status = $1 WHERE id = $2", status, id); err != nil {
+			return fmt.Errorf("shard %d: update failed: %w", shardID, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// lockSegment - заблокировать строку сегмента до конца транзакции и вернуть его статус
+func lockSegment(ctx context.Context, conn *sql.Conn, shardID int, id string) (string, error) {
+	var status string
+
+	err := conn.QueryRowContext(ctx, "SELECT status FROM segments WHERE id = $1 FOR UPDATE", id).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperrors.ErrSegmentNotFound
+		}
+
+		return "", fmt.Errorf("shard %d: failed to lock segment: %w", shardID, err)
+	}
+
+	return status, nil
+}
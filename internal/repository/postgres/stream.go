@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+	apperrors "main/internal/errors"
+)
+
+// shardMembersCursor - постраничное чтение участников сегмента одного шарда по возрастанию user_id
+type shardMembersCursor struct {
+	shardID  int
+	db       *sql.DB
+	after    int
+	buf      []models.SegmentMember
+	finished bool
+}
+
+// fill - дочитать следующую страницу шарда, если буфер пуст
+func (c *shardMembersCursor) fill(ctx context.Context, segmentId string, pageSize int) error {
+	if len(c.buf) > 0 || c.finished {
+		return nil
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT user_id, COALESCE(variant, '')
+		FROM users_segments
+		WHERE segment_id = $1 AND user_id > $2
+		ORDER BY user_id
+		LIMIT $3
+	`, segmentId, c.after, pageSize)
+	if err != nil {
+		return fmt.Errorf("shard %d: failed to query members: %w", c.shardID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.SegmentMember
+		if err := rows.Scan(&m.UserId, &m.Variant); err != nil {
+			return fmt.Errorf("shard %d: failed to scan member: %w", c.shardID, err)
+		}
+		c.buf = append(c.buf, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("shard %d: rows error: %w", c.shardID, err)
+	}
+
+	if len(c.buf) < pageSize {
+		c.finished = true
+	}
+
+	if len(c.buf) > 0 {
+		c.after = c.buf[len(c.buf)-1].UserId
+	}
+
+	return nil
+}
+
+// membersHeap - курсоры шардов, упорядоченные по первому непрочитанному user_id
+type membersHeap []*shardMembersCursor
+
+func (h membersHeap) Len() int           { return len(h) }
+func (h membersHeap) Less(i, j int) bool { return h[i].buf[0].UserId < h[j].buf[0].UserId }
+func (h membersHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *membersHeap) Push(x any)        { *h = append(*h, x.(*shardMembersCursor)) }
+func (h *membersHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+/*
+	StreamSegmentMembers - выдать участников сегмента id с user_id больше after по возрастанию user_id.
+
+Шарды читаются страницами по batchSize и сливаются через кучу, поэтому в памяти держится не больше
+одной страницы на шард. Участники передаются в send пачками по batchSize; ошибка send прерывает чтение.
+Единого снимка нет: участники, добавленные или удаленные во время чтения, могут попасть или не попасть в выдачу
+*/
+func (s *SegmentationStorage) StreamSegmentMembers(ctx context.Context, id string, after int, batchSize int,
+	send func([]models.SegmentMember) error) error {
+	var exists bool
+	err := s.dbShards[0].QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM segments WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check segment existence: %w", err)
+	}
+
+	if !exists {
+		return apperrors.ErrSegmentNotFound
+	}
+
+	h := make(membersHeap, 0, len(s.dbShards))
+
+	for shardID, db := range s.dbShards {
+		c := &shardMembersCursor{shardID: shardID, db: db, after: after}
+		if err := c.fill(ctx, id, batchSize); err != nil {
+			return err
+		}
+
+		if len(c.buf) > 0 {
+			h = append(h, c)
+		}
+	}
+
+	heap.Init(&h)
+
+	batch := make([]models.SegmentMember, 0, batchSize)
+
+	for h.Len() > 0 {
+		c := h[0]
+		batch = append(batch, c.buf[0])
+		c.buf = c.buf[1:]
+
+		if err := c.fill(ctx, id, batchSize); err != nil {
+			return err
+		}
+
+		if len(c.buf) > 0 {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+
+		if len(batch) == batchSize {
+			if err := send(batch); err != nil {
+				return err
+			}
+			batch = make([]models.SegmentMember, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return send(batch)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+)
+
+/*
+	inTwoPhaseTx - выполнить fn на каждом из шардов shardIDs внутри одной распределенной транзакции (2PC).
+
+Шарды обходятся в порядке возрастания id, чтобы параллельные транзакции брали блокировки в одном порядке.
+Если fn вернула ошибку хотя бы на одном шарде, все уже подготовленные транзакции откатываются,
+а сама ошибка возвращается без оберток, чтобы публичные ошибки дошли до пользователя
+*/
+func (s *SegmentationStorage) inTwoPhaseTx(shardIDs []int, fn func(shardID int, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	txID := "tx_" + uuid.New().String()
+	preparedShards := make(map[int]bool)
+
+	sorted := append([]int(nil), shardIDs...)
+	sort.Ints(sorted)
+
+	for _, shardID := range sorted {
+		db, ok := s.dbShards[shardID]
+		if !ok {
+			s.rollbackAll(txID, preparedShards)
+			return fmt.Errorf("shard %d: unknown shard", shardID)
+		}
+
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			s.rollbackAll(txID, preparedShards)
+			return fmt.Errorf("shard %d: failed to get DB connection: %w", shardID, err)
+		}
+
+		defer conn.Close()
+
+		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+			s.rollbackAll(txID, preparedShards)
+			return fmt.Errorf("shard %d: begin failed: %w", shardID, err)
+		}
+
+		if err := fn(shardID, conn); err != nil {
+			_, _ = conn.ExecContext(ctx, "ROLLBACK")
+			s.rollbackAll(txID, preparedShards)
+			return err
+		}
+
+		prepareQuery := fmt.Sprintf("PREPARE TRANSACTION '%s'", txID)
+		if _, err := conn.ExecContext(ctx, prepareQuery); err != nil {
+			s.rollbackAll(txID, preparedShards)
+			return fmt.Errorf("shard %d: prepare failed: %w", shardID, err)
+		}
+
+		preparedShards[shardID] = true
+	}
+
+	if err := s.commitAll(txID, preparedShards); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+
+	return nil
+}
+
+// allShards - id всех шардов хранилища
+func (s *SegmentationStorage) allShards() []int {
+	ids := make([]int, 0, len(s.dbShards))
+
+	for shardID := range s.dbShards {
+		ids = append(ids, shardID)
+	}
+
+	return ids
+}
+
+// groupByShard - разбить id пользователей по шардам, в которых они хранятся
+func (s *SegmentationStorage) groupByShard(userIds []int) map[int][]int {
+	groups := make(map[int][]int)
+
+	for _, id := range userIds {
+		shardNum := id % s.shardsNum
+		groups[shardNum] = append(groups[shardNum], id)
+	}
+
+	return groups
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"main/internal/domain/models"
+)
+
+// insertVariants - сохранить варианты эксперимента сегмента segmentId в порядке их перечисления
+func insertVariants(ctx context.Context, conn *sql.Conn, segmentId string, variants []models.Variant) error {
+	for i, v := range variants {
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO segment_variants (segment_id, name, weight, ord, payload) VALUES ($1, $2, $3, $4, $5)",
+			segmentId, v.Name, v.Weight, i, nullableJSON(v.Payload))
+		if err != nil {
+			return fmt.Errorf("failed to insert variant %q: %w", v.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// variantsInfo - варианты сегмента id с числом пользователей шарда в каждом из них
+func variantsInfo(ctx context.Context, db *sql.DB, id string) ([]models.VariantInfo, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT v.name, v.weight, COUNT(us.user_id), v.payload
+		FROM segment_variants v
+		LEFT JOIN users_segments us ON us.segment_id = v.segment_id AND us.variant = v.name
+		WHERE v.segment_id = $1
+		GROUP BY v.name, v.weight, v.ord, v.payload
+		ORDER BY v.ord
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]models.VariantInfo, 0)
+	for rows.Next() {
+		var vi models.VariantInfo
+		if err := rows.Scan(&vi.Name, &vi.Weight, &vi.UsersNum, (*[]byte)(&vi.Payload)); err != nil {
+			return nil, err
+		}
+		res = append(res, vi)
+	}
+
+	return res, rows.Err()
+}
+
+// mergeVariantsInfo - сложить число пользователей вариантов, посчитанное на разных шардах
+func mergeVariantsInfo(total, shard []models.VariantInfo) []models.VariantInfo {
+	if total == nil {
+		return shard
+	}
+
+	for i := range total {
+		for _, vi := range shard {
+			if vi.Name == total[i].Name {
+				total[i].UsersNum += vi.UsersNum
+			}
+		}
+	}
+
+	return total
+}
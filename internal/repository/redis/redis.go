@@ -96,3 +96,68 @@ func (sc *SegmentationCache) Invalidate() error {
 
 	return nil
 }
+
+// DeleteUserSegments - удалить из кэша сегменты одного пользователя
+func (sc *SegmentationCache) DeleteUserSegments(key int) error {
+	if err := sc.client.Del(context.Background(), fmt.Sprintf("%s:%d", segPrefix, key)).Err(); err != nil {
+		return fmt.Errorf("Redis del failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// TryGetManyUserSegments - прочитать сегменты нескольких пользователей одним MGET. Пользователей без записи в кэше нет в ответе
+func (sc *SegmentationCache) TryGetManyUserSegments(keys []int) (map[int][]models.Segment, error) {
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = fmt.Sprintf("%s:%d", segPrefix, key)
+	}
+
+	vals, err := sc.client.MGet(context.Background(), redisKeys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Redis mget failed: %s", err.Error())
+	}
+
+	res := make(map[int][]models.Segment, len(keys))
+	for i, val := range vals {
+		data, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		var segments []models.Segment
+		if err := json.Unmarshal([]byte(data), &segments); err != nil {
+			sc.log.Error("failed to unmarshal cached segments", slog.Int("user_id", keys[i]), slog.String("error", err.Error()))
+			continue
+		}
+
+		res[keys[i]] = segments
+	}
+
+	return res, nil
+}
+
+// SaveManyUserSegments - записать сегменты нескольких пользователей одним пайплайном
+func (sc *SegmentationCache) SaveManyUserSegments(vals map[int][]models.Segment) error {
+	if len(vals) == 0 {
+		return nil
+	}
+
+	_, err := sc.client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for key, val := range vals {
+			data, err := json.Marshal(val)
+			if err != nil {
+				return fmt.Errorf("failed to marshal segments: %w", err)
+			}
+
+			pipe.Set(context.Background(), fmt.Sprintf("%s:%d", segPrefix, key), data, ttl)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Redis pipeline failed: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package segmentation
+
+import (
+	"fmt"
+	"log/slog"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/domain/rules"
+	"main/internal/domain/setexpr"
+	apperrors "main/internal/errors"
+	"time"
+)
+
+/*
+	EvaluateUser - какие сегменты получил бы пользователь userId с атрибутами attrs, если бы его создали сейчас.
+
+Пользователь не создается и может не существовать: решение принимается в памяти по настройкам распространения сегментов -
+автодобавлению, бакету, условию, глобальной контрольной группе, лимиту участников и слоям, поверх них применяются переопределения пользователя и статус сегмента.
+Членство, уже записанное в бд, не учитывается. Решения возвращаются для всех сегментов по возрастанию id
+*/
+func (s *Segmentation) EvaluateUser(userId int, attrs map[string]any) ([]models.Evaluation, error) {
+	segments, err := s.repo.GetSegmentsTargeting(userId)
+	if err != nil {
+		return nil, apperrors.Convert(s.log, err)
+	}
+
+	e := &evaluator{
+		log:       s.log,
+		userId:    userId,
+		attrs:     attrs,
+		inHoldout: s.repo.InHoldout(userId),
+		now:       time.Now(),
+		segments:  make(map[string]models.SegmentTargeting, len(segments)),
+		targeted:  make(map[string]models.Evaluation, len(segments)),
+		layers:    make(map[string]string),
+		visiting:  make(map[string]bool),
+	}
+
+	for _, seg := range segments {
+		e.segments[seg.Id] = seg
+	}
+
+	// Слои занимаются по возрастанию id сегмента, как при автоматическом добавлении новых пользователей,
+	// поэтому обычные сегменты оцениваются раньше производных, которые могут ссылаться на любые из них
+	for _, seg := range segments {
+		if seg.DerivedExpr == "" {
+			e.target(seg.Id)
+		}
+	}
+
+	res := make([]models.Evaluation, 0, len(segments))
+	for _, seg := range segments {
+		res = append(res, e.decide(seg))
+	}
+
+	return res, nil
+}
+
+// evaluator - оценка членства одного пользователя в сегментах в памяти
+type evaluator struct {
+	log       *slog.Logger
+	userId    int
+	attrs     map[string]any
+	inHoldout bool
+	now       time.Time
+	segments  map[string]models.SegmentTargeting
+	// targeted - решения распространения без учета переопределений и статуса сегмента
+	targeted map[string]models.Evaluation
+	// layers - сегмент, занявший пользователя в слое
+	layers   map[string]string
+	visiting map[string]bool
+}
+
+// decide - итоговое решение по сегменту seg: переопределение и статус сегмента поверх решения распространения
+func (e *evaluator) decide(seg models.SegmentTargeting) models.Evaluation {
+	res := e.target(seg.Id)
+
+	if !seg.IsActive(e.now) {
+		return models.Evaluation{SegmentId: seg.Id, Reason: models.EvaluationReasonInactive, Detail: seg.Status}
+	}
+
+	membership := seg.Segment
+	membership.OverrideOnly = !res.Member
+
+	if member := membership.IsMemberAt(e.now); member != res.Member {
+		return models.Evaluation{SegmentId: seg.Id, Member: member, Reason: models.EvaluationReasonOverride, Detail: seg.Override}
+	}
+
+	return res
+}
+
+// target - решение распространения по сегменту id. Решения запоминаются, производные сегменты вычисляются по исходным
+func (e *evaluator) target(id string) models.Evaluation {
+	if res, ok := e.targeted[id]; ok {
+		return res
+	}
+
+	seg, ok := e.segments[id]
+	if !ok || e.visiting[id] {
+		return models.Evaluation{SegmentId: id, Reason: models.EvaluationReasonNotDistributed}
+	}
+
+	e.visiting[id] = true
+	res := e.targetSegment(seg)
+	delete(e.visiting, id)
+
+	if res.Member && len(seg.Variants) > 0 {
+		weights := make([]int, len(seg.Variants))
+		for i, v := range seg.Variants {
+			weights[i] = v.Weight
+		}
+
+		res.Variant = seg.Variants[bucketing.Variant(seg.BucketKey, seg.Salt, e.userId, weights)].Name
+	}
+
+	e.targeted[id] = res
+
+	return res
+}
+
+// targetSegment - проверки распространения сегмента seg в том же порядке, что и при автоматическом добавлении
+func (e *evaluator) targetSegment(seg models.SegmentTargeting) models.Evaluation {
+	res := models.Evaluation{SegmentId: seg.Id}
+
+	if seg.Status == models.SegmentStatusArchived || (seg.ExpiresAt != nil && !e.now.Before(*seg.ExpiresAt)) {
+		res.Reason = models.EvaluationReasonInactive
+		res.Detail = seg.Status
+		return res
+	}
+
+	if seg.DerivedExpr != "" {
+		return e.targetDerived(seg)
+	}
+
+	if e.inHoldout {
+		res.Reason = models.EvaluationReasonHoldout
+		return res
+	}
+
+	if seg.TargetCount != nil {
+		res.Reason = models.EvaluationReasonCountTarget
+		res.Detail = fmt.Sprintf("target count %d", *seg.TargetCount)
+		return res
+	}
+
+	if seg.TargetBuckets == 0 {
+		res.Reason = models.EvaluationReasonNotDistributed
+		return res
+	}
+
+	if !seg.AutoEnroll {
+		res.Reason = models.EvaluationReasonNoAutoEnroll
+		return res
+	}
+
+	if bucket := bucketing.Bucket(seg.BucketKey, seg.Salt, e.userId); bucket >= seg.TargetBuckets {
+		res.Reason = models.EvaluationReasonBucket
+		res.Detail = fmt.Sprintf("bucket %d, threshold %d", bucket, seg.TargetBuckets)
+		return res
+	}
+
+	if seg.Rule != "" {
+		rule, err := rules.Parse(seg.Rule)
+		if err != nil {
+			e.log.Error("failed to parse segment rule", slog.String("id", seg.Id), slog.String("error", err.Error()))
+		}
+
+		if err != nil || !rule.Match(e.attrs) {
+			res.Reason = models.EvaluationReasonRule
+			res.Detail = seg.Rule
+			return res
+		}
+	}
+
+	if seg.MaxMembers != nil && seg.Members >= *seg.MaxMembers {
+		res.Reason = models.EvaluationReasonFull
+		res.Detail = fmt.Sprintf("max members %d", *seg.MaxMembers)
+		return res
+	}
+
+	if seg.LayerId != "" {
+		if taken, ok := e.layers[seg.LayerId]; ok && taken != seg.Id {
+			res.Reason = models.EvaluationReasonLayer
+			res.Detail = taken
+			return res
+		}
+
+		e.layers[seg.LayerId] = seg.Id
+	}
+
+	res.Member = true
+	res.Reason = models.EvaluationReasonTargeted
+
+	return res
+}
+
+/*
+	targetDerived - членство в производном сегменте seg по решениям распространения исходных сегментов.
+
+Снимок вычислялся при создании сегмента, поэтому нового пользователя в нем нет
+*/
+func (e *evaluator) targetDerived(seg models.SegmentTargeting) models.Evaluation {
+	res := models.Evaluation{SegmentId: seg.Id, Reason: models.EvaluationReasonDerived, Detail: seg.DerivedMode}
+
+	if seg.DerivedMode != models.DerivedModeLive {
+		return res
+	}
+
+	expr, err := setexpr.Parse(seg.DerivedExpr)
+	if err != nil {
+		e.log.Error("failed to parse derived segment expression", slog.String("id", seg.Id), slog.String("error", err.Error()))
+		return res
+	}
+
+	res.Member = expr.Match(func(segmentId string) bool { return e.target(segmentId).Member })
+	res.Detail = expr.String()
+
+	return res
+}
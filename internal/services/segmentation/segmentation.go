@@ -1,9 +1,16 @@
 package segmentation
 
 import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/status"
 	"log/slog"
 	"main/internal/domain/models"
+	"main/internal/domain/rules"
+	"main/internal/domain/setexpr"
 	apperrors "main/internal/errors"
+	"time"
 )
 
 // Segmentation - структура сервиса для управления сегментами
@@ -16,24 +23,59 @@ type Segmentation struct {
 type SegmentationRepository interface {
 	CreateSegment(segment models.Segment) (string, error)
 	DeleteSegment(id string) (string, error)
-	UpdateSegment(id string, newSegment models.Segment) (string, error)
+	UpdateSegment(id string, newSegment models.Segment, clearStartsAt, clearExpiresAt bool) (string, error)
 	GetUserSegments(id int) ([]models.Segment, error)
 	GetSegmentInfo(id string) (models.SegmentInfo, error)
-	DistributeSegment(id string, usersPercentage int) (string, error)
+	DistributeSegment(id string, target models.DistributionTarget, rule *rules.Rule, autoEnroll bool) (models.DistributionResult, error)
+	PreviewDistribution(id string, target models.DistributionTarget, rule *rules.Rule, sampleSize int) (models.DistributionPreview, error)
+	GetExpiredSegments() ([]string, error)
+	AddUsersToSegment(id string, userIds []int) (models.MembershipChange, error)
+	RemoveUsersFromSegment(id string, userIds []int) (models.MembershipChange, error)
+	GetUserSegmentHistory(id int, from, to time.Time) ([]models.HistoryEntry, error)
+	SetSegmentStatus(id string, status string) (string, error)
+	CreateLayer(layer models.Layer) (string, error)
+	ListSegments(filter models.SegmentsFilter) (models.SegmentsPage, error)
+	StreamSegmentMembers(ctx context.Context, id string, after int, batchSize int, send func([]models.SegmentMember) error) error
+	GetManyUserSegments(userIds []int) (map[int][]models.Segment, error)
+	CreateDerivedSegment(segment models.Segment, expr *setexpr.Expr, mode string) (int64, error)
+	InHoldout(id int) bool
+	GetHoldoutInfo() (models.HoldoutInfo, error)
+	SnapshotSegmentStats() error
+	GetSegmentStatsHistory(id string, from, to time.Time) (models.StatsHistory, error)
+	GetSegmentOverlap(ids []string) (models.SegmentOverlap, error)
+	SetUserOverride(override models.Override) error
+	ClearUserOverride(userId int, segmentId string) error
+	GetSegmentsTargeting(userId int) ([]models.SegmentTargeting, error)
+	GetUserMemberships(id int, segmentIds []string) ([]models.Segment, error)
 }
 
 type SegmentationCache interface {
 	SaveUserSegments(key int, val []models.Segment) error
 	TryGetUserSegments(key int) ([]models.Segment, error)
 	Invalidate() error
+	DeleteUserSegments(key int) error
+	TryGetManyUserSegments(keys []int) (map[int][]models.Segment, error)
+	SaveManyUserSegments(vals map[int][]models.Segment) error
 }
 
 func NewSegmentation(log *slog.Logger, repo SegmentationRepository, cache SegmentationCache) *Segmentation {
 	return &Segmentation{log: log, repo: repo, cache: cache}
 }
 
-// CreateSegment - создать сегмент с заданной структурой
+/*
+	CreateSegment - создать сегмент с заданной структурой.
+
+Если соль не задана, генерируется случайная. Если не задан статус, сегмент сразу активен
+*/
 func (s *Segmentation) CreateSegment(segment models.Segment) (string, error) {
+	if segment.Salt == "" {
+		segment.Salt = uuid.New().String()
+	}
+
+	if segment.Status == "" {
+		segment.Status = models.SegmentStatusActive
+	}
+
 	id, err := s.repo.CreateSegment(segment)
 
 	if err != nil {
@@ -62,9 +104,9 @@ func (s *Segmentation) DeleteSegment(id string) (string, error) {
 	return id, nil
 }
 
-// UpdateSegment - исправить поля сегмента с id на поля newSegment
-func (s *Segmentation) UpdateSegment(id string, newSegment models.Segment) (string, error) {
-	id, err := s.repo.UpdateSegment(id, newSegment)
+// UpdateSegment - исправить поля сегмента с id на поля newSegment, при clearStartsAt и clearExpiresAt снять время начала и окончания
+func (s *Segmentation) UpdateSegment(id string, newSegment models.Segment, clearStartsAt, clearExpiresAt bool) (string, error) {
+	id, err := s.repo.UpdateSegment(id, newSegment, clearStartsAt, clearExpiresAt)
 
 	if err != nil {
 		err = apperrors.Convert(s.log, err)
@@ -80,7 +122,7 @@ func (s *Segmentation) UpdateSegment(id string, newSegment models.Segment) (stri
 	return id, nil
 }
 
-// GetUserSegments - получить сегменты по id пользователя
+// GetUserSegments - получить действующие на текущий момент сегменты по id пользователя
 func (s *Segmentation) GetUserSegments(id int) ([]models.Segment, error) {
 	cachedSegments, err := s.cache.TryGetUserSegments(id)
 
@@ -89,7 +131,7 @@ func (s *Segmentation) GetUserSegments(id int) ([]models.Segment, error) {
 	}
 
 	if cachedSegments != nil {
-		return cachedSegments, nil
+		return activeSegments(cachedSegments, time.Now()), nil
 	}
 
 	segments, err := s.repo.GetUserSegments(id)
@@ -103,7 +145,198 @@ func (s *Segmentation) GetUserSegments(id int) ([]models.Segment, error) {
 		s.log.Error("failed to cache segmentation", slog.String("error", err.Error()))
 	}
 
-	return segments, nil
+	return activeSegments(segments, time.Now()), nil
+}
+
+/*
+	CheckMembership - состоит ли пользователь id в каждом из сегментов segmentIds на текущий момент.
+
+Если сегменты пользователя есть в кэше, ответ строится по ним. Иначе из бд читаются только заданные сегменты,
+и результат не кэшируется: в кэше хранится только полный список. Несуществующие сегменты - false
+*/
+func (s *Segmentation) CheckMembership(id int, segmentIds []string) (map[string]bool, error) {
+	segments, err := s.cache.TryGetUserSegments(id)
+	if err != nil {
+		s.log.Error("failed to fetch cached segmentations", slog.String("error", err.Error()))
+	}
+
+	if segments == nil {
+		segments, err = s.repo.GetUserMemberships(id, segmentIds)
+		if err != nil {
+			return nil, apperrors.Convert(s.log, err)
+		}
+	}
+
+	res := make(map[string]bool, len(segmentIds))
+	for _, segId := range segmentIds {
+		res[segId] = false
+	}
+
+	for _, seg := range activeSegments(segments, time.Now()) {
+		if _, ok := res[seg.Id]; ok {
+			res[seg.Id] = true
+		}
+	}
+
+	return res, nil
+}
+
+/*
+	BatchGetUserSegments - получить действующие сегменты нескольких пользователей.
+
+Сначала все пользователи ищутся в кэше одним запросом, промахи читаются из бд и сохраняются в кэш.
+Несуществующие пользователи не считаются ошибкой и возвращаются отдельным списком
+*/
+func (s *Segmentation) BatchGetUserSegments(userIds []int) (map[int][]models.Segment, []int, error) {
+	cached, err := s.cache.TryGetManyUserSegments(userIds)
+	if err != nil {
+		s.log.Error("failed to fetch cached segmentations", slog.String("error", err.Error()))
+		cached = map[int][]models.Segment{}
+	}
+
+	misses := make([]int, 0)
+	for _, id := range userIds {
+		if _, ok := cached[id]; !ok {
+			misses = append(misses, id)
+		}
+	}
+
+	fresh := map[int][]models.Segment{}
+	if len(misses) > 0 {
+		fresh, err = s.repo.GetManyUserSegments(misses)
+		if err != nil {
+			err = apperrors.Convert(s.log, err)
+			return nil, nil, err
+		}
+
+		if err := s.cache.SaveManyUserSegments(fresh); err != nil {
+			s.log.Error("failed to cache segmentations", slog.String("error", err.Error()))
+		}
+	}
+
+	now := time.Now()
+	res := make(map[int][]models.Segment, len(userIds))
+	notFound := make([]int, 0)
+
+	for _, id := range userIds {
+		segments, ok := cached[id]
+		if !ok {
+			segments, ok = fresh[id]
+		}
+
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+
+		res[id] = activeSegments(segments, now)
+	}
+
+	return res, notFound, nil
+}
+
+// SetSegmentStatus - сменить статус сегмента id: приостановить, архивировать или восстановить его
+func (s *Segmentation) SetSegmentStatus(id string, status string) (string, error) {
+	id, err := s.repo.SetSegmentStatus(id, status)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return "", err
+	}
+
+	s.invalidateCache()
+
+	return id, nil
+}
+
+/*
+	CreateDerivedSegment - создать сегмент из выражения над существующими сегментами.
+
+В режиме snapshot состав вычисляется один раз, в режиме live - пересчитывается при каждом изменении исходных сегментов
+*/
+func (s *Segmentation) CreateDerivedSegment(segment models.Segment, expr *setexpr.Expr, mode string) (int64, error) {
+	if segment.Salt == "" {
+		segment.Salt = uuid.New().String()
+	}
+
+	if segment.Status == "" {
+		segment.Status = models.SegmentStatusActive
+	}
+
+	usersNum, err := s.repo.CreateDerivedSegment(segment, expr, mode)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return 0, err
+	}
+
+	if usersNum > 0 {
+		s.invalidateCache()
+	}
+
+	return usersNum, nil
+}
+
+// InHoldout - проверка, что пользователь id в глобальной контрольной группе
+func (s *Segmentation) InHoldout(id int) bool {
+	return s.repo.InHoldout(id)
+}
+
+// GetHoldoutInfo - доля глобальной контрольной группы и ее размер по шардам
+func (s *Segmentation) GetHoldoutInfo() (models.HoldoutInfo, error) {
+	res, err := s.repo.GetHoldoutInfo()
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.HoldoutInfo{}, err
+	}
+
+	return res, nil
+}
+
+// CreateLayer - создать слой взаимоисключающих сегментов
+func (s *Segmentation) CreateLayer(layer models.Layer) (string, error) {
+	id, err := s.repo.CreateLayer(layer)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ListSegments - страница каталога сегментов по фильтру
+func (s *Segmentation) ListSegments(filter models.SegmentsFilter) (models.SegmentsPage, error) {
+	res, err := s.repo.ListSegments(filter)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.SegmentsPage{}, err
+	}
+
+	return res, nil
+}
+
+/*
+	ListSegmentMembers - передать в send участников сегмента id с user_id больше after пачками по batchSize.
+
+Если клиент прервал выгрузку или истек его дедлайн, возвращается Canceled или DeadlineExceeded без логирования ошибки
+*/
+func (s *Segmentation) ListSegmentMembers(ctx context.Context, id string, after int, batchSize int,
+	send func([]models.SegmentMember) error) error {
+	err := s.repo.StreamSegmentMembers(ctx, id, after, batchSize, send)
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			s.log.Info("segment members stream interrupted", slog.String("id", id), slog.String("reason", ctxErr.Error()))
+			return status.FromContextError(ctxErr).Err()
+		}
+
+		return apperrors.Convert(s.log, err)
+	}
+
+	return nil
 }
 
 // GetSegmentInfo - Получить статистику сегмента по id
@@ -118,20 +351,204 @@ func (s *Segmentation) GetSegmentInfo(id string) (models.SegmentInfo, error) {
 	return res, nil
 }
 
-// DistributeSegment - рспространить сегмент id на заданный процент пользователей, если он еще не распространен
-func (s *Segmentation) DistributeSegment(id string, usersPercentage int) (string, error) {
-	id, err := s.repo.DistributeSegment(id, usersPercentage)
+/*
+	DistributeSegment - рспространить сегмент id на заданный процент или число пользователей, подходящих под rule.
+
+Повторный вызов меняет цель: при увеличении добавляются недостающие пользователи, при уменьшении лишние удаляются.
+При autoEnroll в сегмент будут попадать и пользователи, созданные после распространения
+*/
+func (s *Segmentation) DistributeSegment(id string, target models.DistributionTarget, rule *rules.Rule, autoEnroll bool) (models.DistributionResult, error) {
+	res, err := s.repo.DistributeSegment(id, target, rule, autoEnroll)
 
 	if err != nil {
 		err = apperrors.Convert(s.log, err)
-		return "", err
+		return models.DistributionResult{}, err
 	}
 
-	err = s.cache.Invalidate()
+	if res.Added() > 0 || res.Removed() > 0 {
+		s.invalidateCache()
+	}
+
+	return res, nil
+}
+
+// PreviewDistribution - посчитать изменения сегмента id при распространении, ничего не меняя
+func (s *Segmentation) PreviewDistribution(id string, target models.DistributionTarget, rule *rules.Rule, sampleSize int) (models.DistributionPreview, error) {
+	res, err := s.repo.PreviewDistribution(id, target, rule, sampleSize)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.DistributionPreview{}, err
+	}
+
+	return res, nil
+}
+
+// AddUsersToSegment - вручную добавить заданных пользователей в сегмент id
+func (s *Segmentation) AddUsersToSegment(id string, userIds []int) (models.MembershipChange, error) {
+	res, err := s.repo.AddUsersToSegment(id, userIds)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.MembershipChange{}, err
+	}
+
+	if len(res.Changed) > 0 {
+		s.invalidateCache()
+	}
+
+	return res, nil
+}
+
+// RemoveUsersFromSegment - вручную удалить заданных пользователей из сегмента id
+func (s *Segmentation) RemoveUsersFromSegment(id string, userIds []int) (models.MembershipChange, error) {
+	res, err := s.repo.RemoveUsersFromSegment(id, userIds)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.MembershipChange{}, err
+	}
+
+	if len(res.Changed) > 0 {
+		s.invalidateCache()
+	}
+
+	return res, nil
+}
+
+// GetUserSegmentHistory - получить историю членства пользователя id в сегментах за период [from, to)
+func (s *Segmentation) GetUserSegmentHistory(id int, from, to time.Time) ([]models.HistoryEntry, error) {
+	res, err := s.repo.GetUserSegmentHistory(id, from, to)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetSegmentStatsHistory - ежедневные снимки числа участников сегмента id за дни с from по to включительно
+func (s *Segmentation) GetSegmentStatsHistory(id string, from, to time.Time) (models.StatsHistory, error) {
+	res, err := s.repo.GetSegmentStatsHistory(id, from, to)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.StatsHistory{}, err
+	}
+
+	return res, nil
+}
+
+// GetSegmentOverlap - попарные пересечения сегментов ids по всем шардам
+func (s *Segmentation) GetSegmentOverlap(ids []string) (models.SegmentOverlap, error) {
+	res, err := s.repo.GetSegmentOverlap(ids)
+
+	if err != nil {
+		err = apperrors.Convert(s.log, err)
+		return models.SegmentOverlap{}, err
+	}
+
+	return res, nil
+}
+
+/*
+	SetUserOverride - принудительно включить пользователя в сегмент или исключить из него.
+
+Переопределение сразу видно в сегментах пользователя: его запись в кэше удаляется. Истечение переопределения проверяется при выдаче
+*/
+func (s *Segmentation) SetUserOverride(override models.Override) error {
+	err := s.repo.SetUserOverride(override)
+
+	if err != nil {
+		return apperrors.Convert(s.log, err)
+	}
+
+	s.invalidateUserCache(override.UserId)
+
+	return nil
+}
+
+// ClearUserOverride - удалить переопределение членства пользователя userId в сегменте segmentId
+func (s *Segmentation) ClearUserOverride(userId int, segmentId string) error {
+	err := s.repo.ClearUserOverride(userId, segmentId)
+
+	if err != nil {
+		return apperrors.Convert(s.log, err)
+	}
+
+	s.invalidateUserCache(userId)
+
+	return nil
+}
+
+// SnapshotSegmentStats - записать снимок числа участников всех сегментов за текущий день
+func (s *Segmentation) SnapshotSegmentStats() error {
+	return s.repo.SnapshotSegmentStats()
+}
+
+/*
+	DeleteExpiredSegments - удалить все сегменты, срок действия которых истек. Возвращает id удаленных сегментов.
+
+Сегменты удаляются по одному, так что ошибка на одном из них не мешает удалить остальные
+*/
+func (s *Segmentation) DeleteExpiredSegments() ([]string, error) {
+	ids, err := s.repo.GetExpiredSegments()
+
+	if err != nil {
+		return nil, apperrors.Convert(s.log, err)
+	}
+
+	deleted := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		_, err := s.repo.DeleteSegment(id)
+
+		if err != nil {
+			if !errors.Is(err, apperrors.ErrSegmentNotFound) {
+				s.log.Error("failed to delete expired segment", slog.String("id", id), slog.String("error", err.Error()))
+			}
+
+			continue
+		}
+
+		deleted = append(deleted, id)
+	}
+
+	if len(deleted) > 0 {
+		s.invalidateCache()
+	}
+
+	return deleted, nil
+}
+
+// invalidateCache - сбросить кэш сегментов пользователей. Ошибка только логируется
+func (s *Segmentation) invalidateCache() {
+	err := s.cache.Invalidate()
 
 	if err != nil {
 		s.log.Error("failed to invalidate cache segmentation", slog.String("error", err.Error()))
 	}
+}
 
-	return id, nil
+// invalidateUserCache - удалить из кэша сегменты пользователя userId. Ошибка только логируется
+func (s *Segmentation) invalidateUserCache(userId int) {
+	err := s.cache.DeleteUserSegments(userId)
+
+	if err != nil {
+		s.log.Error("failed to invalidate user segments cache", slog.Int("user_id", userId), slog.String("error", err.Error()))
+	}
+}
+
+// activeSegments - оставить только сегменты, действующие в момент now и включающие пользователя с учетом переопределений
+func activeSegments(segments []models.Segment, now time.Time) []models.Segment {
+	res := make([]models.Segment, 0, len(segments))
+
+	for _, seg := range segments {
+		if seg.IsActive(now) && seg.IsMemberAt(now) {
+			res = append(res, seg)
+		}
+	}
+
+	return res
 }
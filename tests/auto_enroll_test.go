@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/repository/postgres"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+// TestAutoEnrollment - новый пользователь попадает только в сегменты с автодобавлением,
+// если его бакет меньше порога и атрибуты подходят под условие. Пользователи создаются напрямую через хранилище
+func TestAutoEnrollment(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	dsns := make([]string, 0, len(st.Cfg.Db.Shards))
+	for _, shard := range st.Cfg.Db.Shards {
+		dsns = append(dsns, shard.DSN)
+	}
+
+	storage, err := postgres.NewSegmentationStorage(st.Cfg.Db.NumShards, dsns, bucketing.NewHoldout(0, "global_holdout"),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	autoId := "AUTO_ENROLL_TEST"
+	manualId := "AUTO_ENROLL_DISABLED_TEST"
+	salt := "auto-enroll-test"
+
+	for _, id := range []string{autoId, manualId} {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id, Salt: salt})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range []string{autoId, manualId} {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              autoId,
+		UsersPercentage: "50",
+		Filter:          `country == "RU"`,
+		AutoEnroll:      true,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: manualId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	// Пользователи внутри и за порогом распространения сегмента с автодобавлением
+	threshold := bucketing.PercentageToBuckets(50)
+	var inside, outside int
+	for id := 1000000400; inside == 0 || outside == 0; id++ {
+		switch {
+		case bucketing.Bucket(autoId, salt, id) < threshold && inside == 0:
+			inside = id
+		case bucketing.Bucket(autoId, salt, id) >= threshold && outside == 0:
+			outside = id
+		}
+	}
+	mismatched := inside + 1
+	for bucketing.Bucket(autoId, salt, mismatched) >= threshold || mismatched == outside {
+		mismatched++
+	}
+
+	users := []models.User{
+		{Id: inside, Attributes: map[string]any{"country": "RU"}},
+		{Id: outside, Attributes: map[string]any{"country": "RU"}},
+		{Id: mismatched, Attributes: map[string]any{"country": "US"}},
+	}
+
+	for _, user := range users {
+		_, _ = storage.DeleteUser(user.Id)
+
+		_, err := storage.CreateUser(user)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_, _ = storage.DeleteUser(user.Id)
+		})
+	}
+
+	isMember := func(userId int, segmentId string) bool {
+		segments, err := storage.GetUserMemberships(userId, []string{segmentId})
+		require.NoError(t, err)
+		return len(segments) == 1 && !segments[0].OverrideOnly
+	}
+
+	assert.True(t, isMember(inside, autoId), "user inside the rollout not enrolled")
+	assert.False(t, isMember(outside, autoId), "user outside the rollout enrolled")
+	assert.False(t, isMember(mismatched, autoId), "user not matching the filter enrolled")
+
+	for _, user := range users {
+		assert.False(t, isMember(user.Id, manualId), "user %d enrolled into a segment without auto-enroll", user.Id)
+	}
+
+	// После выключения автодобавления новые пользователи в сегмент больше не попадают
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              autoId,
+		UsersPercentage: "50",
+		Filter:          `country == "RU"`,
+	})
+	require.NoError(t, err)
+
+	_, err = storage.DeleteUser(inside)
+	require.NoError(t, err)
+
+	_, err = storage.CreateUser(users[0])
+	require.NoError(t, err)
+
+	assert.False(t, isMember(inside, autoId), "user enrolled after auto-enroll was turned off")
+}
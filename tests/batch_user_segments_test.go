@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestBatchGetUserSegmentsReportsUnknownUsers(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	unknownIds := []int64{1000000011, 1000000012}
+
+	resp, err := st.AuthClient.BatchGetUserSegments(ctx, &segv1.BatchGetUserSegmentsRequest{UserIds: unknownIds})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Users)
+	assert.ElementsMatch(t, unknownIds, resp.NotFoundUserIds)
+
+	_, err = st.AuthClient.BatchGetUserSegments(ctx, &segv1.BatchGetUserSegmentsRequest{})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestBatchGetUserSegmentsMixedUsers - известные пользователи с разных шардов вперемешку с неизвестными.
+// Первый запрос читает промахи кэша из бд, второй - из кэша, оба совпадают с GetUserSegments
+func TestBatchGetUserSegmentsMixedUsers(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "BATCH_USER_SEGMENTS_TEST"
+
+	// Подряд идущие id хранятся на разных шардах
+	userIds := st.SeedUsers(1000004000, st.Cfg.Db.NumShards+1)
+	unknownIds := []int64{1000004900, 1000004901}
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	// Добавление сбрасывает кэш добавленных пользователей, оставшийся от прошлых запусков
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: userIds[1:]})
+	require.NoError(t, err)
+
+	reqIds := []int64{userIds[0], unknownIds[0]}
+	reqIds = append(reqIds, userIds[1:]...)
+	reqIds = append(reqIds, unknownIds[1])
+
+	categories := func(categs []*segv1.CategoryInfo) []string {
+		res := make([]string, 0, len(categs))
+		for _, c := range categs {
+			res = append(res, fmt.Sprintf("%s %s %t", c.Id, c.Variant, c.Forced))
+		}
+		return res
+	}
+
+	for range 2 {
+		resp, err := st.AuthClient.BatchGetUserSegments(ctx, &segv1.BatchGetUserSegmentsRequest{UserIds: reqIds})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, unknownIds, resp.NotFoundUserIds)
+		require.Len(t, resp.Users, len(userIds))
+
+		for i, user := range resp.Users {
+			assert.Equal(t, userIds[i], user.UserId, "users must keep the request order")
+
+			single, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: user.UserId})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, categories(single.Categories), categories(user.Categories), "user %d", user.UserId)
+
+			if i > 0 {
+				assert.Contains(t, categories(user.Categories), segId+"  false", "user %d", user.UserId)
+			}
+		}
+	}
+}
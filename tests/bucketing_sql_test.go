@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"main/internal/domain/bucketing"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+// TestBucketMatchesSQL - автодобавление и EvaluateUser считают бакет в Go, распространение - в postgres.
+// Для одних и тех же (ключ, соль, пользователь) бакеты должны совпадать
+func TestBucketMatchesSQL(t *testing.T) {
+	ctx, st := suite.New(t)
+	db := st.Shard()
+
+	keys := []string{"NEW_UI", "checkout:variant", "global_holdout", "Сегмент"}
+	salts := []string{"", "abc", "global_holdout", "соль с пробелом"}
+	query := `SELECT ` + bucketing.SQL("$1::text", "$2::text", "$3::int")
+
+	for _, key := range keys {
+		for _, salt := range salts {
+			for _, userId := range []int{0, 1, 2, 7, 42, 999, 123456, 2147483647} {
+				var got int
+				require.NoError(t, db.QueryRowContext(ctx, query, key, salt, userId).Scan(&got))
+				assert.Equal(t, bucketing.Bucket(key, salt, userId), got, "%s:%s:%d", key, salt, userId)
+			}
+		}
+	}
+}
+
+// TestHoldoutMatchesSQL - автодобавление и EvaluateUser проверяют контрольную группу через Contains,
+// распространение - через SQL. Состав группы должен совпадать
+func TestHoldoutMatchesSQL(t *testing.T) {
+	ctx, st := suite.New(t)
+	db := st.Shard()
+
+	holdouts := []bucketing.Holdout{
+		bucketing.NewHoldout(0, "global_holdout"),
+		bucketing.NewHoldout(0.01, "global_holdout"),
+		bucketing.NewHoldout(20, "global_holdout"),
+		bucketing.NewHoldout(50, "другая соль"),
+		bucketing.NewHoldout(100, "global_holdout"),
+	}
+
+	for _, holdout := range holdouts {
+		cond, args := holdout.SQL("u.id", nil)
+		query := `SELECT u.id, ` + cond + ` FROM generate_series(0, 999) AS u(id)`
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		require.NoError(t, err)
+
+		checked := 0
+		for rows.Next() {
+			var userId int
+			var inHoldout bool
+			require.NoError(t, rows.Scan(&userId, &inHoldout))
+
+			assert.Equal(t, holdout.Contains(userId), inHoldout, "%v%% %s: user %d", holdout.Percentage(), holdout.Salt, userId)
+			checked++
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+
+		assert.Equal(t, 1000, checked)
+	}
+}
+
+// TestDistributionMatchesBucket - распространение в postgres выбирает ровно тех пользователей,
+// чей бакет в Go меньше целевого, а триггер назначает тот же вариант, что и bucketing.Variant
+func TestDistributionMatchesBucket(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "BUCKETING_AGREEMENT_TEST"
+	salt := "bucketing-agreement"
+	weights := []int{30, 70}
+	names := []string{"a", "b"}
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:       segId,
+		Salt:     salt,
+		Variants: []*segv1.Variant{{Name: names[0], Weight: int32(weights[0])}, {Name: names[1], Weight: int32(weights[1])}},
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      50,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to check")
+	}
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "40"})
+	require.NoError(t, err)
+
+	targetBuckets := bucketing.PercentageToBuckets(40)
+
+	// Пользователи контрольной группы сервиса не распространяются независимо от бакета
+	holdout, err := st.AuthClient.GetHoldoutInfo(ctx, &segv1.GetHoldoutInfoRequest{})
+	require.NoError(t, err)
+
+	inHoldout := func(userId int64) bool {
+		if holdout.Percentage == 0 {
+			return false
+		}
+
+		info, err := st.AuthClient.GetHoldoutInfo(ctx, &segv1.GetHoldoutInfoRequest{UserId: &userId})
+		require.NoError(t, err)
+		return info.GetUserInHoldout()
+	}
+
+	for _, userId := range preview.SampleUserIds {
+		resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+		require.NoError(t, err)
+
+		variant := ""
+		for _, c := range resp.Categories {
+			if c.Id == segId {
+				variant = c.Variant
+			}
+		}
+
+		if bucketing.Bucket(segId, salt, int(userId)) >= targetBuckets || inHoldout(userId) {
+			assert.Empty(t, variant, "user %d must not be distributed", userId)
+			continue
+		}
+
+		assert.Equal(t, names[bucketing.Variant(segId, salt, int(userId), weights)], variant, "user %d", userId)
+	}
+}
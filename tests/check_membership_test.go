@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestCheckMembership(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "CHECK_MEMBERSHIP_TEST"
+	missingId := "CHECK_MEMBERSHIP_MISSING"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to check")
+	}
+	userId := preview.SampleUserIds[0]
+
+	resp, err := st.AuthClient.CheckMembership(ctx, &segv1.CheckMembershipRequest{
+		UserId:     userId,
+		SegmentIds: []string{segId, missingId},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{segId: false, missingId: false}, resp.Memberships)
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{userId}})
+	require.NoError(t, err)
+
+	resp, err = st.AuthClient.CheckMembership(ctx, &segv1.CheckMembershipRequest{
+		UserId:     userId,
+		SegmentIds: []string{segId, missingId},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{segId: true, missingId: false}, resp.Memberships)
+
+	segments, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+	require.NoError(t, err)
+
+	// Второй запрос идет по кэшу, заполненному GetUserSegments
+	cached, err := st.AuthClient.CheckMembership(ctx, &segv1.CheckMembershipRequest{UserId: userId, SegmentIds: []string{segId}})
+	require.NoError(t, err)
+	assert.True(t, cached.Memberships[segId])
+	assert.NotEmpty(t, segments.Categories)
+
+	_, err = st.AuthClient.CheckMembership(ctx, &segv1.CheckMembershipRequest{
+		UserId:     1000000041,
+		SegmentIds: []string{segId},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.CheckMembership(ctx, &segv1.CheckMembershipRequest{UserId: userId})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
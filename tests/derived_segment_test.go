@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestLiveDerivedSegment(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	includedId := "DERIVED_TEST_INCLUDED"
+	excludedId := "DERIVED_TEST_EXCLUDED"
+	derivedId := "DERIVED_TEST_RESULT"
+
+	for _, id := range []string{includedId, excludedId} {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range []string{derivedId, includedId, excludedId} {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	_, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: includedId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.CreateDerivedSegment(ctx, &segv1.CreateDerivedSegmentRequest{
+		Id:         derivedId,
+		Expression: includedId + " except NO_SUCH_SEGMENT",
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.CreateDerivedSegment(ctx, &segv1.CreateDerivedSegmentRequest{Id: derivedId, Expression: includedId + " except"})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := st.AuthClient.CreateDerivedSegment(ctx, &segv1.CreateDerivedSegmentRequest{
+		Id:         derivedId,
+		Expression: includedId + " except " + excludedId,
+		Mode:       segv1.DerivedMode_DERIVED_MODE_LIVE,
+	})
+	require.NoError(t, err)
+
+	included, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: includedId})
+	require.NoError(t, err)
+	assert.Equal(t, included.UsersNum, created.UsersNum)
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: excludedId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	derived, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: derivedId})
+	require.NoError(t, err)
+	assert.Zero(t, derived.UsersNum)
+	assert.Equal(t, segv1.DerivedMode_DERIVED_MODE_LIVE, derived.DerivedMode)
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestDistributeByCount(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "DISTRIBUTE_COUNT_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	usersCount := int64(5000)
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "10",
+		UsersCount:      &usersCount,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "0.5"})
+	require.NoError(t, err)
+
+	// При 100% в целевую выборку попадают все подходящие пользователи
+	full, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "100", DryRun: true})
+	require.NoError(t, err)
+	eligible := min(full.Assigned, usersCount)
+
+	distrSeg, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersCount: &usersCount})
+	require.NoError(t, err)
+
+	var requested int64
+	for _, sh := range distrSeg.Shards {
+		// Доли считаются по подходящим пользователям, поэтому каждый шард выбирает ровно свою долю
+		assert.Equal(t, sh.Requested, sh.Assigned, "shard %d", sh.Shard)
+		requested += sh.Requested
+	}
+
+	assert.Equal(t, eligible, requested)
+	assert.Equal(t, eligible, distrSeg.Assigned)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	require.NotNil(t, info.TargetUsers)
+	assert.Equal(t, usersCount, *info.TargetUsers)
+	assert.Equal(t, distrSeg.Assigned, info.UsersNum)
+}
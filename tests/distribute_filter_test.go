@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestDistributeWithFilter(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "DISTRIBUTE_FILTER_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:          segId,
+		Description: "Segment for filtered distribution test",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "50",
+		Filter:          `country in ("RU", "KZ") && platform = "ios"`,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "invalid filter")
+
+	distrSeg, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "50",
+		Filter:          `country in ("RU", "KZ") && platform == "ios"`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, segId, distrSeg.Id)
+}
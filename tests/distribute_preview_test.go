@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestDistributePreview(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "DISTRIBUTE_PREVIEW_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "10",
+		DryRun:          true,
+		SampleSize:      5,
+	})
+	require.NoError(t, err)
+	assert.True(t, preview.DryRun)
+	assert.Zero(t, preview.Removed)
+	assert.Equal(t, preview.Added, preview.Assigned)
+	assert.LessOrEqual(t, len(preview.SampleUserIds), 5)
+	assert.IsNonDecreasing(t, preview.SampleUserIds)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Zero(t, info.UsersNum)
+
+	distrSeg, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "10"})
+	require.NoError(t, err)
+	assert.False(t, distrSeg.DryRun)
+	assert.Equal(t, preview.Added, distrSeg.Added)
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "10",
+		DryRun:          true,
+		SampleSize:      -1,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"main/internal/domain/bucketing"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+// TestIncrementalRollout - при увеличении процента прежние участники остаются и добавляются только новые,
+// при уменьшении удаляются только участники по распространению за новым порогом, вручную добавленные остаются
+func TestIncrementalRollout(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "INCREMENTAL_ROLLOUT_TEST"
+	salt := "incremental-rollout"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId, Salt: salt})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      100,
+	})
+	require.NoError(t, err)
+
+	// Вручную добавляется пользователь, которого не выбирает ни один из процентов теста
+	var manualUser int64
+	for _, userId := range preview.SampleUserIds {
+		if bucketing.Bucket(segId, salt, int(userId)) >= bucketing.PercentageToBuckets(50) {
+			manualUser = userId
+			break
+		}
+	}
+	if manualUser == 0 {
+		t.Skip("no users to check")
+	}
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{manualUser}})
+	require.NoError(t, err)
+
+	members := func() map[int64]bool {
+		stream, err := st.AuthClient.ListSegmentMembers(ctx, &segv1.ListSegmentMembersRequest{Id: segId, BatchSize: 10000})
+		require.NoError(t, err)
+
+		res := make(map[int64]bool)
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return res
+			}
+			require.NoError(t, err)
+
+			for _, m := range chunk.Members {
+				res[m.UserId] = true
+			}
+		}
+	}
+
+	distribute := func(percentage string) *segv1.DistributeSegmentResponse {
+		resp, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: percentage})
+		require.NoError(t, err)
+		return resp
+	}
+
+	small := distribute("20")
+	assert.Zero(t, small.Removed)
+	smallMembers := members()
+	assert.True(t, smallMembers[manualUser])
+	assert.Equal(t, small.Added+1, int64(len(smallMembers)))
+
+	grown := distribute("50")
+	assert.Zero(t, grown.Removed, "raising the percentage must not remove users")
+	grownMembers := members()
+	assert.Equal(t, int64(len(smallMembers))+grown.Added, int64(len(grownMembers)))
+
+	for userId := range smallMembers {
+		assert.True(t, grownMembers[userId], "user %d lost when raising the percentage", userId)
+	}
+
+	shrunk := distribute("10")
+	assert.Zero(t, shrunk.Added, "lowering the percentage must not add users")
+	shrunkMembers := members()
+	assert.Equal(t, int64(len(grownMembers))-shrunk.Removed, int64(len(shrunkMembers)))
+	assert.True(t, shrunkMembers[manualUser], "manually added user removed when lowering the percentage")
+
+	for userId := range shrunkMembers {
+		assert.True(t, smallMembers[userId], "user %d added when lowering the percentage", userId)
+
+		if userId != manualUser {
+			assert.Less(t, bucketing.Bucket(segId, salt, int(userId)), bucketing.PercentageToBuckets(10), "user %d", userId)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestEvaluateUser(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "EVALUATE_USER_TEST"
+	userId := int64(1000000031)
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	findDecision := func(attrs map[string]any) *segv1.SegmentDecision {
+		pbAttrs, err := structpb.NewStruct(attrs)
+		require.NoError(t, err)
+
+		resp, err := st.AuthClient.EvaluateUser(ctx, &segv1.EvaluateUserRequest{UserId: userId, Attributes: pbAttrs})
+		require.NoError(t, err)
+		assert.Equal(t, userId, resp.UserId)
+
+		if resp.InHoldout {
+			t.Skip("user is in global holdout")
+		}
+
+		for _, d := range resp.Decisions {
+			if d.SegmentId == segId {
+				return d
+			}
+		}
+		return nil
+	}
+
+	decision := findDecision(nil)
+	require.NotNil(t, decision)
+	assert.False(t, decision.Member)
+	assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_NOT_DISTRIBUTED, decision.Reason)
+
+	// Без автодобавления новые пользователи в сегмент не попадают
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		Filter:          `country == "RU"`,
+	})
+	require.NoError(t, err)
+
+	decision = findDecision(map[string]any{"country": "RU"})
+	require.NotNil(t, decision)
+	assert.False(t, decision.Member)
+	assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_NO_AUTO_ENROLL, decision.Reason)
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		Filter:          `country == "RU"`,
+		AutoEnroll:      true,
+	})
+	require.NoError(t, err)
+
+	decision = findDecision(map[string]any{"country": "RU"})
+	require.NotNil(t, decision)
+	assert.True(t, decision.Member)
+	assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_TARGETED, decision.Reason)
+
+	decision = findDecision(map[string]any{"country": "US"})
+	require.NotNil(t, decision)
+	assert.False(t, decision.Member)
+	assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_RULE, decision.Reason)
+
+	// В заполненный до лимита сегмент новые пользователи не попадают
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) > 0 {
+		_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: preview.SampleUserIds})
+		require.NoError(t, err)
+
+		info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewMaxMembers: &info.UsersNum})
+		require.NoError(t, err)
+
+		decision = findDecision(map[string]any{"country": "RU"})
+		require.NotNil(t, decision)
+		assert.False(t, decision.Member)
+		assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_FULL, decision.Reason)
+
+		noLimit := int64(0)
+		_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewMaxMembers: &noLimit})
+		require.NoError(t, err)
+	}
+
+	_, err = st.AuthClient.SetSegmentStatus(ctx, &segv1.SetSegmentStatusRequest{
+		Id:     segId,
+		Status: segv1.SegmentStatus_SEGMENT_STATUS_PAUSED,
+	})
+	require.NoError(t, err)
+
+	decision = findDecision(map[string]any{"country": "RU"})
+	require.NotNil(t, decision)
+	assert.False(t, decision.Member)
+	assert.Equal(t, segv1.EvaluationReason_EVALUATION_REASON_INACTIVE, decision.Reason)
+
+	_, err = st.AuthClient.EvaluateUser(ctx, &segv1.EvaluateUserRequest{UserId: -1})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/domain/setexpr"
+	"main/internal/repository/postgres"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestGetHoldoutInfo(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userId := int64(1)
+
+	info, err := st.AuthClient.GetHoldoutInfo(ctx, &segv1.GetHoldoutInfoRequest{UserId: &userId})
+	require.NoError(t, err)
+	require.NotNil(t, info.UserInHoldout)
+	require.NotEmpty(t, info.Shards)
+
+	var usersNum, holdoutUsers int64
+	for _, sh := range info.Shards {
+		assert.LessOrEqual(t, sh.HoldoutUsers, sh.UsersNum)
+		usersNum += sh.UsersNum
+		holdoutUsers += sh.HoldoutUsers
+	}
+
+	assert.Equal(t, usersNum, info.UsersNum)
+	assert.Equal(t, holdoutUsers, info.HoldoutUsers)
+
+	if info.Percentage == 0 {
+		assert.False(t, *info.UserInHoldout)
+		assert.Zero(t, info.HoldoutUsers)
+	}
+}
+
+// TestHoldoutUsersAreNotDistributed - распространение, автодобавление и производные сегменты пропускают
+// пользователей контрольной группы. У запущенного сервиса группа может быть выключена, поэтому тест работает
+// с хранилищем с собственной группой поверх той же бд
+func TestHoldoutUsersAreNotDistributed(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	dsns := make([]string, 0, len(st.Cfg.Db.Shards))
+	for _, shard := range st.Cfg.Db.Shards {
+		dsns = append(dsns, shard.DSN)
+	}
+
+	holdout := bucketing.NewHoldout(30, "holdout-distribution-test")
+	storage, err := postgres.NewSegmentationStorage(st.Cfg.Db.NumShards, dsns, holdout, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	segId := "HOLDOUT_DISTRIBUTION_TEST"
+	derivedId := "HOLDOUT_DERIVED_TEST"
+
+	_, err = storage.CreateSegment(models.Segment{Id: segId, Salt: "holdout-test", Status: models.SegmentStatusActive})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = storage.DeleteSegment(derivedId)
+		_, _ = storage.DeleteSegment(segId)
+	})
+
+	_, err = storage.DistributeSegment(segId, models.DistributionTarget{Percentage: 100}, nil, true)
+	require.NoError(t, err)
+
+	stream, err := st.AuthClient.ListSegmentMembers(ctx, &segv1.ListSegmentMembersRequest{Id: segId})
+	require.NoError(t, err)
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		for _, m := range chunk.Members {
+			assert.False(t, holdout.Contains(int(m.UserId)), "holdout user %d distributed", m.UserId)
+		}
+	}
+
+	var holdoutUser, regularUser int
+	for id := 1000000200; holdoutUser == 0 || regularUser == 0; id++ {
+		switch {
+		case holdout.Contains(id) && holdoutUser == 0:
+			holdoutUser = id
+		case !holdout.Contains(id) && regularUser == 0:
+			regularUser = id
+		}
+	}
+
+	for _, id := range []int{holdoutUser, regularUser} {
+		_, _ = storage.DeleteUser(id)
+
+		_, err := storage.CreateUser(models.User{Id: id})
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_, _ = storage.DeleteUser(id)
+		})
+	}
+
+	isMember := func(userId int, segmentId string) bool {
+		segments, err := storage.GetUserMemberships(userId, []string{segmentId})
+		require.NoError(t, err)
+		return len(segments) == 1 && !segments[0].OverrideOnly
+	}
+
+	assert.True(t, isMember(regularUser, segId))
+	assert.False(t, isMember(holdoutUser, segId), "holdout user auto-enrolled")
+
+	// Вручную добавленный пользователь контрольной группы остается в исходном сегменте, но не попадает в производный
+	_, err = storage.AddUsersToSegment(segId, []int{holdoutUser})
+	require.NoError(t, err)
+	require.True(t, isMember(holdoutUser, segId))
+
+	expr, err := setexpr.Parse(segId)
+	require.NoError(t, err)
+
+	_, err = storage.CreateDerivedSegment(models.Segment{Id: derivedId, Salt: "holdout-test", Status: models.SegmentStatusActive},
+		expr, models.DerivedModeLive)
+	require.NoError(t, err)
+
+	assert.True(t, isMember(regularUser, derivedId))
+	assert.False(t, isMember(holdoutUser, derivedId), "holdout user got into derived segment")
+}
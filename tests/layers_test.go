@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+	"time"
+)
+
+// TestLayerSegmentsAreExclusive - пользователь попадает не больше чем в один сегмент слоя. Тесты идут параллельно
+// и создают пользователей, поэтому проверяются только участники сегментов теста, а не свободные пользователи слоя
+func TestLayerSegmentsAreExclusive(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	layerId := fmt.Sprintf("LAYER_TEST_%d", time.Now().UnixNano())
+	firstId := "LAYER_TEST_FIRST"
+	secondId := "LAYER_TEST_SECOND"
+
+	userIds := st.SeedUsers(1000001000, 20)
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: "LAYER_TEST_UNKNOWN", LayerId: layerId})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.CreateLayer(ctx, &segv1.CreateLayerRequest{Id: layerId, Description: "Layer for exclusivity test"})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.CreateLayer(ctx, &segv1.CreateLayerRequest{Id: layerId})
+	require.Error(t, err)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	for _, id := range []string{firstId, secondId} {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id, LayerId: layerId})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range []string{firstId, secondId} {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	first, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: firstId, UsersPercentage: "50"})
+	require.NoError(t, err)
+	assert.Positive(t, first.Added)
+
+	// Второй сегмент получает только пользователей, не занятых первым
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: secondId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	firstMembers := st.SegmentMembers(ctx, firstId)
+	secondMembers := st.SegmentMembers(ctx, secondId)
+	assert.NotEmpty(t, firstMembers)
+	assert.NotEmpty(t, secondMembers)
+
+	for userId := range firstMembers {
+		_, ok := secondMembers[userId]
+		assert.False(t, ok, "user %d is a member of both segments of the layer", userId)
+	}
+
+	// Созданные тестом пользователи попадают в сегменты слоя
+	var seeded int
+	for _, userId := range userIds {
+		_, inFirst := firstMembers[userId]
+		_, inSecond := secondMembers[userId]
+		if inFirst || inSecond {
+			seeded++
+		}
+	}
+	assert.Positive(t, seeded)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: secondId})
+	require.NoError(t, err)
+	assert.Equal(t, layerId, info.LayerId)
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestListSegmentsPagination(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	ids := []string{"LIST_TEST_1", "LIST_TEST_2", "LIST_TEST_3"}
+
+	for _, id := range ids {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id, Description: "Segment for list test"})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	listed := make([]string, 0, len(ids))
+	pageToken := ""
+
+	for {
+		page, err := st.AuthClient.ListSegments(ctx, &segv1.ListSegmentsRequest{
+			IdPrefix:   "LIST_TEST_",
+			PageSize:   2,
+			PageToken:  pageToken,
+			WithCounts: true,
+		})
+		require.NoError(t, err)
+
+		for _, seg := range page.Segments {
+			listed = append(listed, seg.Id)
+			require.NotNil(t, seg.UsersNum)
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	assert.Equal(t, ids, listed)
+
+	page, err := st.AuthClient.ListSegments(ctx, &segv1.ListSegmentsRequest{IdPrefix: "LIST_TEST_", DescriptionContains: "no such text"})
+	require.NoError(t, err)
+	assert.Empty(t, page.Segments)
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestManualMembership(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "MANUAL_MEMBERSHIP_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:          segId,
+		Description: "Segment for manual membership test",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	unknownIds := []int64{1000000001, 1000000002}
+
+	addResp, err := st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{
+		Id:      segId,
+		UserIds: unknownIds,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, addResp.AddedIds)
+	assert.ElementsMatch(t, unknownIds, addResp.UnknownUserIds)
+
+	removeResp, err := st.AuthClient.RemoveUsersFromSegment(ctx, &segv1.RemoveUsersFromSegmentRequest{
+		Id:      segId,
+		UserIds: unknownIds,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, removeResp.RemovedIds)
+	assert.ElementsMatch(t, unknownIds, removeResp.UnknownUserIds)
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      2,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) < 2 {
+		t.Skip("not enough users to check")
+	}
+	first, second := preview.SampleUserIds[0], preview.SampleUserIds[1]
+
+	addResp, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{
+		Id:      segId,
+		UserIds: []int64{first, unknownIds[0]},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{first}, addResp.AddedIds)
+	assert.Equal(t, []int64{unknownIds[0]}, addResp.UnknownUserIds)
+	assert.Empty(t, addResp.AlreadyMemberIds)
+
+	// Уже состоящие в сегменте пользователи не добавляются повторно
+	addResp, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{
+		Id:      segId,
+		UserIds: []int64{first, second},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{second}, addResp.AddedIds)
+	assert.Equal(t, []int64{first}, addResp.AlreadyMemberIds)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.UsersNum)
+
+	removeResp, err = st.AuthClient.RemoveUsersFromSegment(ctx, &segv1.RemoveUsersFromSegmentRequest{
+		Id:      segId,
+		UserIds: []int64{first, unknownIds[0]},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{first}, removeResp.RemovedIds)
+	assert.Equal(t, []int64{unknownIds[0]}, removeResp.UnknownUserIds)
+	assert.Empty(t, removeResp.NotMemberIds)
+
+	// Пользователи не из сегмента не удаляются
+	removeResp, err = st.AuthClient.RemoveUsersFromSegment(ctx, &segv1.RemoveUsersFromSegmentRequest{
+		Id:      segId,
+		UserIds: []int64{first, second},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{second}, removeResp.RemovedIds)
+	assert.Equal(t, []int64{first}, removeResp.NotMemberIds)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Zero(t, info.UsersNum)
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{
+		Id:      "NO_SUCH_SEGMENT",
+		UserIds: unknownIds,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestSegmentMemberCap(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "MEMBER_CAP_TEST"
+	maxMembers := int64(10)
+
+	invalid := int64(-1)
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId, MaxMembers: &invalid})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId, MaxMembers: &maxMembers})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	require.NotNil(t, info.MaxMembers)
+	require.NotNil(t, info.RemainingCapacity)
+	assert.Equal(t, maxMembers, *info.MaxMembers)
+	assert.Equal(t, maxMembers, *info.RemainingCapacity)
+
+	// Пользователей заведомо больше лимита, поэтому распространение останавливается на лимите
+	userIds := st.SeedUsers(1000003000, 3*int(maxMembers))
+
+	distrSeg, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "100"})
+	require.NoError(t, err)
+	assert.Equal(t, maxMembers, distrSeg.Added)
+	assert.Positive(t, distrSeg.Rejected)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, maxMembers, info.UsersNum)
+	require.NotNil(t, info.RemainingCapacity)
+	assert.Zero(t, *info.RemainingCapacity)
+
+	members := st.SegmentMembers(ctx, segId)
+	outsiders := make([]int64, 0, len(userIds))
+	for _, userId := range userIds {
+		if _, ok := members[userId]; !ok {
+			outsiders = append(outsiders, userId)
+		}
+	}
+	require.GreaterOrEqual(t, len(outsiders), 5)
+	outsiders = outsiders[:5]
+
+	// Заполненный сегмент отклоняет ручное добавление
+	added, err := st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: outsiders})
+	require.NoError(t, err)
+	assert.Empty(t, added.AddedIds)
+	assert.ElementsMatch(t, outsiders, added.RejectedIds)
+
+	// После увеличения лимита добавляется ровно столько пользователей, сколько осталось мест
+	newMaxMembers := maxMembers + 2
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewMaxMembers: &newMaxMembers})
+	require.NoError(t, err)
+
+	added, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: outsiders})
+	require.NoError(t, err)
+	assert.Len(t, added.AddedIds, 2)
+	assert.Len(t, added.RejectedIds, len(outsiders)-2)
+	assert.ElementsMatch(t, outsiders, append(added.AddedIds, added.RejectedIds...))
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, newMaxMembers, info.UsersNum)
+	require.NotNil(t, info.RemainingCapacity)
+	assert.Zero(t, *info.RemainingCapacity)
+
+	noLimit := int64(0)
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewMaxMembers: &noLimit})
+	require.NoError(t, err)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Nil(t, info.MaxMembers)
+	assert.Nil(t, info.RemainingCapacity)
+}
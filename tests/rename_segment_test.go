@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestRenameSegment(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	oldId := "RENAME_TEST_OLD"
+	newId := "RENAME_TEST_NEW"
+	takenId := "RENAME_TEST_TAKEN"
+
+	for _, id := range []string{oldId, takenId} {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id, Description: "Segment for rename test"})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range []string{oldId, newId, takenId} {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	_, err := st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: oldId, NewId: &takenId})
+	require.Error(t, err)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	updSeg, err := st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: oldId, NewId: &newId})
+	require.NoError(t, err)
+	assert.Equal(t, newId, updSeg.Id)
+
+	infoSeg, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: newId})
+	require.NoError(t, err)
+	assert.Equal(t, "Segment for rename test", infoSeg.Description)
+
+	_, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: oldId})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"main/internal/domain/rules"
+	"main/tests/suite"
+	"testing"
+)
+
+// TestRuleMatchAgreesWithSQL - автодобавление проверяет условие через Match, распространение - через SQL.
+// Для каждого оператора и пользователей с отсутствующими и разнотипными атрибутами результаты должны совпадать
+func TestRuleMatchAgreesWithSQL(t *testing.T) {
+	ctx, st := suite.New(t)
+	db := st.Shard()
+
+	users := []string{
+		`{}`,
+		`{"country": "RU", "platform": "ios", "signup_date": "2024-03-01", "age": 30, "premium": true, "plan": "pro"}`,
+		`{"country": "KZ", "platform": "android", "signup_date": "2023-12-31", "age": 17.5, "premium": false}`,
+		`{"country": "ru", "age": "30", "premium": "true", "signup_date": 20240301}`,
+		`{"country": null, "age": null, "plan": ["pro"], "premium": 1}`,
+		`{"country": "Россия", "age": -1, "plan": {"name": "pro"}}`,
+	}
+
+	exprs := []string{
+		`country == "RU"`,
+		`age == 30`,
+		`age == 30.0`,
+		`premium == true`,
+		`premium == false`,
+		`country != "RU"`,
+		`age != 30`,
+		`premium != true`,
+		`age < 30`,
+		`age <= 30`,
+		`age > 17`,
+		`age >= -1`,
+		`signup_date < "2024-01-01"`,
+		`signup_date >= "2024-01-01"`,
+		`country >= "RU"`,
+		`country <= "ru"`,
+		`country in ("RU", "KZ")`,
+		`age in (30, "30")`,
+		`premium in (true, 1)`,
+		`country not in ("RU", "KZ")`,
+		`plan not in ("pro")`,
+		`!(country == "RU")`,
+		`country == "RU" && age >= 18`,
+		`country == "KZ" || premium == true`,
+		`!(age < 18 || country not in ("RU")) && signup_date >= "2024-01-01"`,
+	}
+
+	for _, src := range exprs {
+		rule, err := rules.Parse(src)
+		require.NoError(t, err, src)
+
+		for _, user := range users {
+			var attrs map[string]any
+			require.NoError(t, json.Unmarshal([]byte(user), &attrs))
+
+			cond, args := rule.SQL("u.attributes", []any{user})
+
+			var got bool
+			err := db.QueryRowContext(ctx, `SELECT `+cond+` FROM (SELECT $1::jsonb AS attributes) u`, args...).Scan(&got)
+			require.NoError(t, err, src)
+
+			assert.Equal(t, rule.Match(attrs), got, "%s on %s", src, user)
+		}
+	}
+}
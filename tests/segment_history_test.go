@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log/slog"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/repository/postgres"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUserSegmentHistory - история пишет причину каждого изменения членства, переносится на новый id
+// при переименовании сегмента и фильтруется по периоду. Пользователь создается и удаляется напрямую через хранилище
+func TestUserSegmentHistory(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	dsns := make([]string, 0, len(st.Cfg.Db.Shards))
+	for _, shard := range st.Cfg.Db.Shards {
+		dsns = append(dsns, shard.DSN)
+	}
+
+	storage, err := postgres.NewSegmentationStorage(st.Cfg.Db.NumShards, dsns, bucketing.NewHoldout(0, "global_holdout"),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+
+	userId := 1000000300
+	distributedId := "HISTORY_DISTRIBUTED_TEST"
+	manualId := "HISTORY_MANUAL_TEST"
+	renamedId := "HISTORY_RENAMED_TEST"
+	deletedId := "HISTORY_DELETED_TEST"
+
+	holdoutUserId := int64(userId)
+	info, err := st.AuthClient.GetHoldoutInfo(ctx, &segv1.GetHoldoutInfoRequest{UserId: &holdoutUserId})
+	require.NoError(t, err)
+	if info.GetUserInHoldout() {
+		t.Skip("user is in global holdout")
+	}
+
+	_, _ = storage.DeleteUser(userId)
+
+	// Записи прошлых запусков отсекаются началом периода
+	start := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = storage.CreateUser(models.User{Id: userId})
+	require.NoError(t, err)
+
+	for _, id := range []string{distributedId, manualId, deletedId} {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		_, _ = storage.DeleteUser(userId)
+
+		for _, id := range []string{distributedId, manualId, renamedId, deletedId} {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: distributedId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	for _, id := range []string{manualId, deletedId} {
+		_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: id, UserIds: []int64{int64(userId)}})
+		require.NoError(t, err)
+	}
+
+	_, err = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: deletedId})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: manualId, NewId: &renamedId})
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = storage.DeleteUser(userId)
+	require.NoError(t, err)
+
+	history := func(from, to time.Time) []string {
+		req := &segv1.GetUserSegmentHistoryRequest{UserId: int64(userId), From: timestamppb.New(from)}
+		if !to.IsZero() {
+			req.To = timestamppb.New(to)
+		}
+
+		resp, err := st.AuthClient.GetUserSegmentHistory(ctx, req)
+		require.NoError(t, err)
+
+		entries := make([]string, 0, len(resp.Entries))
+		for _, e := range resp.Entries {
+			entries = append(entries, fmt.Sprintf("%s %s %s %s", e.SegmentId, e.Action, e.Reason, e.PreviousSegmentId))
+		}
+		return entries
+	}
+
+	beforeDeletion := []string{
+		distributedId + " add distribution ",
+		renamedId + " add manual ",
+		deletedId + " add manual ",
+		deletedId + " remove segment_deletion ",
+		renamedId + " rename segment_rename " + manualId,
+	}
+	userDeletion := []string{
+		distributedId + " remove user_deletion ",
+		renamedId + " remove user_deletion ",
+	}
+
+	assert.ElementsMatch(t, append(beforeDeletion, userDeletion...), history(start, time.Time{}))
+	assert.ElementsMatch(t, beforeDeletion, history(start, middle))
+	assert.ElementsMatch(t, userDeletion, history(middle, time.Time{}))
+
+	resp, err := st.AuthClient.GetUserSegmentHistory(ctx, &segv1.GetUserSegmentHistoryRequest{
+		UserId: int64(userId),
+		From:   timestamppb.New(start),
+		AsCsv:  true,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Entries)
+
+	records, err := csv.NewReader(strings.NewReader(resp.Csv)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, len(beforeDeletion)+len(userDeletion)+1)
+	assert.Equal(t, []string{"user_id", "segment_id", "action", "reason", "created_at", "previous_segment_id"}, records[0])
+
+	csvEntries := make([]string, 0, len(records)-1)
+	for _, r := range records[1:] {
+		assert.Equal(t, fmt.Sprint(userId), r[0])
+
+		_, err := time.Parse(time.RFC3339, r[4])
+		assert.NoError(t, err)
+
+		csvEntries = append(csvEntries, fmt.Sprintf("%s %s %s %s", r[1], r[2], r[3], r[5]))
+	}
+	assert.ElementsMatch(t, append(beforeDeletion, userDeletion...), csvEntries)
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestListSegmentMembers(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "SEGMENT_MEMBERS_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+
+	readAll := func(req *segv1.ListSegmentMembersRequest) []int64 {
+		stream, err := st.AuthClient.ListSegmentMembers(ctx, req)
+		require.NoError(t, err)
+
+		ids := make([]int64, 0)
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return ids
+			}
+			require.NoError(t, err)
+
+			for _, m := range chunk.Members {
+				ids = append(ids, m.UserId)
+			}
+			assert.Equal(t, ids[len(ids)-1], chunk.Cursor)
+		}
+	}
+
+	ids := readAll(&segv1.ListSegmentMembersRequest{Id: segId, BatchSize: 3})
+	require.Len(t, ids, int(info.UsersNum))
+
+	for i := 1; i < len(ids); i++ {
+		assert.Less(t, ids[i-1], ids[i])
+	}
+
+	if len(ids) > 1 {
+		cursor := ids[0]
+		assert.Equal(t, ids[1:], readAll(&segv1.ListSegmentMembersRequest{Id: segId, AfterUserId: &cursor, BatchSize: 2}))
+	}
+
+	stream, err := st.AuthClient.ListSegmentMembers(ctx, &segv1.ListSegmentMembersRequest{Id: "NO_SUCH_SEGMENT"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestSegmentOverlap(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	ids := []string{"OVERLAP_TEST_WIDE", "OVERLAP_TEST_NARROW"}
+	percentages := []string{"50", "10"}
+
+	for i, id := range ids {
+		_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: id})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: id, UsersPercentage: percentages[i]})
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: id})
+		}
+	})
+
+	overlap, err := st.AuthClient.GetSegmentOverlap(ctx, &segv1.GetSegmentOverlapRequest{SegmentIds: ids, WithJaccard: true})
+	require.NoError(t, err)
+	assert.Equal(t, ids, overlap.SegmentIds)
+	assert.False(t, overlap.Partial)
+	require.Len(t, overlap.Rows, len(ids))
+
+	for i, id := range ids {
+		info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: id})
+		require.NoError(t, err)
+		assert.Equal(t, info.UsersNum, overlap.Rows[i].Counts[i])
+
+		for j := range ids {
+			assert.Equal(t, overlap.Rows[i].Counts[j], overlap.Rows[j].Counts[i])
+			assert.LessOrEqual(t, overlap.Rows[i].Counts[j], overlap.Rows[i].Counts[i])
+			assert.InDelta(t, overlap.Rows[i].Jaccard[j], overlap.Rows[j].Jaccard[i], 1e-9)
+			assert.GreaterOrEqual(t, overlap.Rows[i].Jaccard[j], 0.0)
+			assert.LessOrEqual(t, overlap.Rows[i].Jaccard[j], 1.0)
+		}
+	}
+
+	_, err = st.AuthClient.GetSegmentOverlap(ctx, &segv1.GetSegmentOverlapRequest{SegmentIds: []string{ids[0], "NO_SUCH_SEGMENT"}})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.GetSegmentOverlap(ctx, &segv1.GetSegmentOverlapRequest{SegmentIds: []string{ids[0], ids[0]}})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestSegmentPayload(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "SEGMENT_PAYLOAD_TEST"
+	schema := `{"type": "object", "properties": {"color": {"type": "string"}, "limit": {"type": "integer", "minimum": 1}},
+		"required": ["color"], "additionalProperties": false}`
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:            segId,
+		Payload:       `{"color": 5}`,
+		PayloadSchema: schema,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "payload does not match payload schema", status.Convert(err).Message())
+
+	_, err = st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:            segId,
+		Payload:       `{"color": "red"}`,
+		PayloadSchema: `{"$ref": "#/definitions/flag"}`,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:            segId,
+		Payload:       `{"color": "red", "limit": 10}`,
+		PayloadSchema: schema,
+		Variants: []*segv1.Variant{
+			{Name: "control", Weight: 1},
+			{Name: "treatment", Weight: 1, Payload: `{"color": "green", "limit": 20}`},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"color": "red", "limit": 10}`, info.Payload)
+	assert.JSONEq(t, schema, info.PayloadSchema)
+
+	// Новая схема не подходит под сохраненные параметры
+	strictSchema := `{"type": "object", "required": ["speed"]}`
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewPayloadSchema: &strictSchema})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{
+		Id:                 segId,
+		NewVariantPayloads: map[string]string{"missing": `{"color": "blue"}`},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	newPayload := `{"color": "blue"}`
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, NewPayload: &newPayload})
+	require.NoError(t, err)
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to check payload")
+	}
+	userId := preview.SampleUserIds[0]
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{userId}})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+	require.NoError(t, err)
+
+	var categ *segv1.CategoryInfo
+	for _, c := range resp.Categories {
+		if c.Id == segId {
+			categ = c
+		}
+	}
+	require.NotNil(t, categ)
+
+	expected := newPayload
+	if categ.Variant == "treatment" {
+		expected = `{"color": "green", "limit": 20}`
+	}
+	assert.JSONEq(t, expected, categ.Payload)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestSegmentSchedule(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "SEGMENT_SCHEDULE_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:       segId,
+		StartsAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to check")
+	}
+	userId := preview.SampleUserIds[0]
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{userId}})
+	require.NoError(t, err)
+
+	hasSegment := func() bool {
+		resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+		require.NoError(t, err)
+
+		for _, categ := range resp.Categories {
+			if categ.Id == segId {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Сегмент еще не начал действовать
+	assert.False(t, hasSegment())
+
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{
+		Id:            segId,
+		StartsAt:      timestamppb.New(time.Now()),
+		ClearStartsAt: true,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Время окончания сверяется с сохраненным временем начала
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{
+		Id:        segId,
+		ExpiresAt: timestamppb.New(time.Now().Add(30 * time.Minute)),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Нулевое время - обычное время, а не снятие
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, StartsAt: timestamppb.New(time.Time{})})
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	require.NotNil(t, info.StartsAt)
+	assert.True(t, info.StartsAt.AsTime().Equal(time.Time{}))
+
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, ClearStartsAt: true})
+	require.NoError(t, err)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Nil(t, info.StartsAt)
+	assert.True(t, hasSegment())
+
+	// Время окончания задается и снимается, пока сегмент действует
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{
+		Id:        segId,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	require.NotNil(t, info.ExpiresAt)
+	assert.True(t, hasSegment())
+
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{Id: segId, ClearExpiresAt: true})
+	require.NoError(t, err)
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Nil(t, info.ExpiresAt)
+
+	// Истекший сегмент пропадает у пользователя еще до того, как его удалит sweeper
+	_, err = st.AuthClient.UpdateSegment(ctx, &segv1.UpdateSegmentRequest{
+		Id:        segId,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Second)),
+	})
+	require.NoError(t, err)
+	assert.True(t, hasSegment())
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.False(t, hasSegment())
+}
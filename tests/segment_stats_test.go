@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestSegmentStats(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "SEGMENT_STATS_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "10"})
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.False(t, info.Partial)
+	assert.Empty(t, info.FailedShards)
+	require.NotEmpty(t, info.Shards)
+
+	var usersNum int64
+	for _, sh := range info.Shards {
+		usersNum += sh.UsersNum
+	}
+	assert.Equal(t, info.UsersNum, usersNum)
+
+	history, err := st.AuthClient.GetSegmentStatsHistory(ctx, &segv1.GetSegmentStatsHistoryRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, segId, history.Id)
+	assert.False(t, history.Partial)
+
+	for _, p := range history.Points {
+		assert.LessOrEqual(t, p.Shards, int32(len(info.Shards)))
+	}
+
+	_, err = st.AuthClient.GetSegmentStatsHistory(ctx, &segv1.GetSegmentStatsHistoryRequest{Id: "NO_SUCH_SEGMENT"})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	now := time.Now()
+	_, err = st.AuthClient.GetSegmentStatsHistory(ctx, &segv1.GetSegmentStatsHistoryRequest{
+		Id:   segId,
+		From: timestamppb.New(now),
+		To:   timestamppb.New(now.Add(-48 * time.Hour)),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+func TestPausedSegmentKeepsMembers(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "SEGMENT_STATUS_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to check")
+	}
+	userId := preview.SampleUserIds[0]
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{userId}})
+	require.NoError(t, err)
+
+	hasSegment := func() bool {
+		resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+		require.NoError(t, err)
+
+		for _, categ := range resp.Categories {
+			if categ.Id == segId {
+				return true
+			}
+		}
+		return false
+	}
+
+	setStatus := func(segStatus segv1.SegmentStatus) {
+		resp, err := st.AuthClient.SetSegmentStatus(ctx, &segv1.SetSegmentStatusRequest{Id: segId, Status: segStatus})
+		require.NoError(t, err)
+		assert.Equal(t, segStatus, resp.Status)
+	}
+
+	require.True(t, hasSegment())
+
+	_, err = st.AuthClient.SetSegmentStatus(ctx, &segv1.SetSegmentStatusRequest{Id: segId})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// На паузе сегмент пропадает у пользователя, но участники остаются
+	setStatus(segv1.SegmentStatus_SEGMENT_STATUS_PAUSED)
+	assert.False(t, hasSegment())
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, segv1.SegmentStatus_SEGMENT_STATUS_PAUSED, info.Status)
+	assert.Equal(t, int64(1), info.UsersNum)
+
+	// После возобновления сегмент снова виден с прежними участниками
+	setStatus(segv1.SegmentStatus_SEGMENT_STATUS_ACTIVE)
+	assert.True(t, hasSegment())
+
+	info, err = st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	assert.Equal(t, segv1.SegmentStatus_SEGMENT_STATUS_ACTIVE, info.Status)
+	assert.Equal(t, int64(1), info.UsersNum)
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"main/internal/domain/setexpr"
+	"main/tests/suite"
+	"sort"
+	"testing"
+)
+
+// TestSetExprMatchAgreesWithSQL - EvaluateUser вычисляет производные сегменты через Match, пересчет состава - через SQL.
+// Запрос выполняется над фиксированными участниками, подставленными вместо users_segments через CTE
+func TestSetExprMatchAgreesWithSQL(t *testing.T) {
+	ctx, st := suite.New(t)
+	db := st.Shard()
+
+	// Пользователь i состоит в A, B и C по битам i: все 8 сочетаний
+	members := map[int]map[string]bool{}
+	values := ""
+	for userId := 0; userId < 8; userId++ {
+		members[userId] = map[string]bool{"A": userId&1 != 0, "B": userId&2 != 0, "C D": userId&4 != 0}
+
+		for segmentId, in := range members[userId] {
+			if !in {
+				continue
+			}
+
+			if values != "" {
+				values += ", "
+			}
+			values += fmt.Sprintf("(%d, '%s')", userId, segmentId)
+		}
+	}
+
+	exprs := []string{
+		`A union B`,
+		`A intersect B`,
+		`A except B`,
+		`A union B intersect "C D"`,
+		`A except B intersect "C D"`,
+		`A except B except "C D"`,
+		`A except (B except "C D")`,
+		`(A union B) except "C D"`,
+		`"C D" intersect (A union B) except A`,
+	}
+
+	for _, src := range exprs {
+		expr, err := setexpr.Parse(src)
+		require.NoError(t, err, src)
+
+		query, args := expr.SQL(nil)
+
+		rows, err := db.QueryContext(ctx, `
+			WITH users_segments (user_id, segment_id) AS (VALUES `+values+`)
+			SELECT r.user_id FROM `+query+` r`, args...)
+		require.NoError(t, err, src)
+
+		got := make([]int, 0)
+		for rows.Next() {
+			var userId int
+			require.NoError(t, rows.Scan(&userId))
+			got = append(got, userId)
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+		sort.Ints(got)
+
+		want := make([]int, 0)
+		for userId := 0; userId < 8; userId++ {
+			if expr.Match(func(segmentId string) bool { return members[userId][segmentId] }) {
+				want = append(want, userId)
+			}
+		}
+
+		assert.Equal(t, want, got, src)
+	}
+}
@@ -2,12 +2,20 @@ package suite
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
 	"main/internal/config"
+	"main/internal/domain/bucketing"
+	"main/internal/domain/models"
+	"main/internal/repository/postgres"
 	"net"
 	"os"
 	"strconv"
 	"testing"
 
+	_ "github.com/lib/pq"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	segv1 "main/protos/gen/go/segmentation"
@@ -17,6 +25,8 @@ type Suite struct {
 	*testing.T                          // Потребуется для вызова методов *testing.T внутри Suite
 	Cfg        *config.Config           // Конфигурация приложения
 	AuthClient segv1.SegmentationClient // Клиент для взаимодействия с gRPC-сервером
+
+	storage *postgres.SegmentationStorage
 }
 
 const (
@@ -53,6 +63,98 @@ func New(t *testing.T) (context.Context, *Suite) {
 	}
 }
 
+// Shard открывает прямое подключение к первому шарду БД. Нужно для проверок SQL-выражений,
+// которые не видны через gRPC
+func (s *Suite) Shard() *sql.DB {
+	s.Helper()
+
+	db, err := sql.Open("postgres", s.Cfg.Db.Shards[0].DSN)
+	if err != nil {
+		s.Fatalf("db connection failed: %v", err)
+	}
+
+	s.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// Storage открывает хранилище напрямую через все шарды. Нужно для создания и удаления пользователей,
+// для которых нет методов gRPC
+func (s *Suite) Storage() *postgres.SegmentationStorage {
+	s.Helper()
+
+	if s.storage != nil {
+		return s.storage
+	}
+
+	dsns := make([]string, 0, len(s.Cfg.Db.Shards))
+	for _, shard := range s.Cfg.Db.Shards {
+		dsns = append(dsns, shard.DSN)
+	}
+
+	storage, err := postgres.NewSegmentationStorage(s.Cfg.Db.NumShards, dsns, bucketing.NewHoldout(0, "global_holdout"),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		s.Fatalf("storage connection failed: %v", err)
+	}
+
+	s.storage = storage
+	return storage
+}
+
+// SeedUsers создает count пользователей без атрибутов с id начиная с from и удаляет их по окончании теста.
+// Оставшиеся от прошлых запусков пользователи с теми же id удаляются заранее. Тесты идут параллельно,
+// поэтому у каждого теста свой диапазон id
+func (s *Suite) SeedUsers(from, count int) []int64 {
+	s.Helper()
+
+	storage := s.Storage()
+	ids := make([]int64, 0, count)
+
+	for id := from; id < from+count; id++ {
+		_, _ = storage.DeleteUser(id)
+
+		if _, err := storage.CreateUser(models.User{Id: id}); err != nil {
+			s.Fatalf("failed to create user %d: %v", id, err)
+		}
+
+		s.Cleanup(func() {
+			_, _ = storage.DeleteUser(id)
+		})
+
+		ids = append(ids, int64(id))
+	}
+
+	return ids
+}
+
+// SegmentMembers выгружает всех участников сегмента id: вариант эксперимента по id пользователя
+func (s *Suite) SegmentMembers(ctx context.Context, id string) map[int64]string {
+	s.Helper()
+
+	stream, err := s.AuthClient.ListSegmentMembers(ctx, &segv1.ListSegmentMembersRequest{Id: id, BatchSize: 10000})
+	if err != nil {
+		s.Fatalf("failed to list members of %s: %v", id, err)
+	}
+
+	res := make(map[int64]string)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return res
+		}
+		if err != nil {
+			s.Fatalf("failed to list members of %s: %v", id, err)
+		}
+
+		for _, m := range chunk.Members {
+			res[m.UserId] = m.Variant
+		}
+	}
+}
+
 func configPath() string {
 	const key = "CONFIG_PATH"
 
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestUserOverrides(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "USER_OVERRIDES_TEST"
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	preview, err := st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{
+		Id:              segId,
+		UsersPercentage: "100",
+		DryRun:          true,
+		SampleSize:      1,
+	})
+	require.NoError(t, err)
+
+	if len(preview.SampleUserIds) == 0 {
+		t.Skip("no users to override")
+	}
+	userId := preview.SampleUserIds[0]
+
+	findCategory := func() *segv1.CategoryInfo {
+		resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+		require.NoError(t, err)
+
+		for _, categ := range resp.Categories {
+			if categ.Id == segId {
+				return categ
+			}
+		}
+		return nil
+	}
+
+	require.Nil(t, findCategory())
+
+	_, err = st.AuthClient.SetUserOverride(ctx, &segv1.SetUserOverrideRequest{
+		UserId:    userId,
+		SegmentId: segId,
+		Action:    segv1.OverrideAction_OVERRIDE_ACTION_INCLUDE,
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	categ := findCategory()
+	require.NotNil(t, categ)
+	assert.True(t, categ.Forced)
+
+	_, err = st.AuthClient.AddUsersToSegment(ctx, &segv1.AddUsersToSegmentRequest{Id: segId, UserIds: []int64{userId}})
+	require.NoError(t, err)
+
+	categ = findCategory()
+	require.NotNil(t, categ)
+	assert.False(t, categ.Forced)
+
+	_, err = st.AuthClient.SetUserOverride(ctx, &segv1.SetUserOverrideRequest{
+		UserId:    userId,
+		SegmentId: segId,
+		Action:    segv1.OverrideAction_OVERRIDE_ACTION_EXCLUDE,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, findCategory())
+
+	_, err = st.AuthClient.ClearUserOverride(ctx, &segv1.ClearUserOverrideRequest{UserId: userId, SegmentId: segId})
+	require.NoError(t, err)
+	assert.NotNil(t, findCategory())
+
+	_, err = st.AuthClient.ClearUserOverride(ctx, &segv1.ClearUserOverrideRequest{UserId: userId, SegmentId: segId})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUserOverridesValidation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.SetUserOverride(ctx, &segv1.SetUserOverrideRequest{UserId: 1, SegmentId: "ANY"})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.SetUserOverride(ctx, &segv1.SetUserOverrideRequest{
+		UserId:    1,
+		SegmentId: "ANY",
+		Action:    segv1.OverrideAction_OVERRIDE_ACTION_INCLUDE,
+		ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour)),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.SetUserOverride(ctx, &segv1.SetUserOverrideRequest{
+		UserId:    1000000021,
+		SegmentId: "NO_SUCH_SEGMENT",
+		Action:    segv1.OverrideAction_OVERRIDE_ACTION_INCLUDE,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"main/internal/domain/bucketing"
+	segv1 "main/protos/gen/go/segmentation"
+	"main/tests/suite"
+	"testing"
+)
+
+// TestExperimentVariants - каждый участник сегмента попадает ровно в один вариант, выбранный по весам,
+// и видит его имя в сегментах пользователя
+func TestExperimentVariants(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	segId := "VARIANTS_TEST"
+	salt := "variants-test"
+	variants := []*segv1.Variant{{Name: "control", Weight: 50}, {Name: "a", Weight: 25}, {Name: "b", Weight: 25}}
+
+	userIds := st.SeedUsers(1000002000, 20)
+
+	_, err := st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{
+		Id:       segId,
+		Variants: []*segv1.Variant{{Name: "control", Weight: 50}, {Name: "control", Weight: 50}},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.CreateSegment(ctx, &segv1.CreateSegmentRequest{Id: segId, Salt: salt, Variants: variants})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, _ = st.AuthClient.DeleteSegment(ctx, &segv1.DeleteSegmentRequest{Id: segId})
+	})
+
+	_, err = st.AuthClient.DistributeSegment(ctx, &segv1.DistributeSegmentRequest{Id: segId, UsersPercentage: "100"})
+	require.NoError(t, err)
+
+	info, err := st.AuthClient.GetSegmentInfo(ctx, &segv1.GetSegmentInfoRequest{Id: segId})
+	require.NoError(t, err)
+	require.Len(t, info.Variants, 3)
+
+	var inVariants int64
+	for _, v := range info.Variants {
+		inVariants += v.UsersNum
+	}
+	assert.Equal(t, info.UsersNum, inVariants)
+
+	weights := make([]int, 0, len(variants))
+	for _, v := range variants {
+		weights = append(weights, int(v.Weight))
+	}
+
+	members := st.SegmentMembers(ctx, segId)
+
+	var checked int
+	for _, userId := range userIds {
+		variant, ok := members[userId]
+		if !ok {
+			// Пользователь в глобальном холдауте
+			continue
+		}
+		checked++
+
+		want := variants[bucketing.Variant(segId, salt, int(userId), weights)].Name
+		assert.Equal(t, want, variant, "user %d", userId)
+
+		resp, err := st.AuthClient.GetUserSegments(ctx, &segv1.GetUserSegmentsRequest{Id: userId})
+		require.NoError(t, err)
+
+		var found int
+		for _, categ := range resp.Categories {
+			if categ.Id == segId {
+				found++
+				assert.Equal(t, want, categ.Variant, "user %d", userId)
+			}
+		}
+		assert.Equal(t, 1, found, "user %d", userId)
+	}
+	assert.Positive(t, checked)
+}